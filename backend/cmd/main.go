@@ -5,28 +5,152 @@ import (
 	"backend/internal/config"
 	"backend/internal/engine"
 	"backend/internal/storage"
+	"context"
+	"encoding/json"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// Each root has naming rules that decide which names can be synced to it
+	localNames := nameProfile(config.LocalNameProfileEnv, config.LocalEscapeNamesEnv)
+	remoteNames := nameProfile(config.RemoteNameProfileEnv, config.RemoteEscapeNamesEnv)
+
 	// Create a local storage provider
+	var localProvider storage.StorageProvider
 	localProvider, err := storage.NewFileSystemProvider(config.LOCAL_PATH)
 	if err != nil {
 		log.Fatalf("Failed to initialize local provider: %v", err)
 	}
+	if localNames.Escape {
+		localProvider = storage.NewEscapedNamesProvider(localProvider, localNames)
+		log.Printf("Local names escaped for %s\n", localNames.Name)
+	}
 
 	// Create a remote storage provider
-	remoteProvider, err := storage.NewFileSystemProvider(config.REMOTE_PATH)
+	var remoteProvider storage.StorageProvider
+	remoteProvider, err = storage.NewFileSystemProvider(config.REMOTE_PATH)
 	if err != nil {
 		log.Fatalf("Failed to initialize remote provider: %v", err)
 	}
 
+	// Escape names right above the file system, so it is what sees them
+	if remoteNames.Escape {
+		remoteProvider = storage.NewEscapedNamesProvider(remoteProvider, remoteNames)
+		log.Printf("Remote names escaped for %s\n", remoteNames.Name)
+	}
+
+	// Encrypt the remote side when a passphrase or key file is configured
+	encryption := storage.EncryptionConfig{
+		Passphrase:   os.Getenv(config.RemoteEncryptionPassphraseEnv),
+		KeyFile:      os.Getenv(config.RemoteEncryptionKeyFileEnv),
+		EncryptNames: os.Getenv(config.RemoteEncryptNamesEnv) == "true",
+	}
+	if encryption.Passphrase != "" || encryption.KeyFile != "" {
+		remoteProvider, err = storage.NewEncryptedProvider(remoteProvider, encryption)
+		if err != nil {
+			log.Fatalf("Failed to initialize remote encryption: %v", err)
+		}
+		log.Println("Remote storage encryption enabled")
+	}
+
+	// Compress before encrypting so the ciphertext stays small
+	if level := os.Getenv(config.RemoteCompressionLevelEnv); level != "" {
+		lvl, err := strconv.Atoi(level)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", config.RemoteCompressionLevelEnv, err)
+		}
+		remoteProvider, err = storage.NewCompressedProvider(remoteProvider, storage.CompressionConfig{Level: lvl})
+		if err != nil {
+			log.Fatalf("Failed to initialize remote compression: %v", err)
+		}
+		log.Printf("Remote storage compression enabled (level %d)\n", lvl)
+	}
+
+	// Chunk on top of compression and encryption so identical chunks still dedupe
+	if os.Getenv(config.RemoteChunkStoreEnv) == "true" {
+		remoteProvider = storage.NewChunkStoreProvider(remoteProvider)
+		log.Println("Remote chunk store enabled")
+	}
+
 	// Create sync engine instance
 	syncEngine, err := engine.NewSyncEngine(localProvider, remoteProvider)
 	if err != nil {
 		log.Fatalf("Failed to create sync engine: %v", err)
 	}
 
+	// Periodic reconciliation is off unless the environment sets an interval or cron expression
+	schedule := syncEngine.GetScheduleStatus().Schedule
+	if interval := os.Getenv(config.ScheduleIntervalEnv); interval != "" {
+		if schedule.Interval, err = time.ParseDuration(interval); err != nil {
+			log.Fatalf("Invalid %s: %v", config.ScheduleIntervalEnv, err)
+		}
+	}
+	if jitter := os.Getenv(config.ScheduleJitterEnv); jitter != "" {
+		if schedule.Jitter, err = time.ParseDuration(jitter); err != nil {
+			log.Fatalf("Invalid %s: %v", config.ScheduleJitterEnv, err)
+		}
+	}
+	schedule.Cron = os.Getenv(config.ScheduleCronEnv)
+	schedule.FullScanWindow = os.Getenv(config.ScheduleFullScanWindowEnv)
+	if err := syncEngine.SetSchedule(schedule); err != nil {
+		log.Fatalf("Invalid sync schedule: %v", err)
+	}
+
+	// The worker pool adapts to load within configurable bounds
+	pool := engine.DefaultPoolConfig()
+	if minWorkers := os.Getenv(config.MinWorkersEnv); minWorkers != "" {
+		if pool.MinWorkers, err = strconv.Atoi(minWorkers); err != nil {
+			log.Fatalf("Invalid %s: %v", config.MinWorkersEnv, err)
+		}
+	}
+	if maxWorkers := os.Getenv(config.MaxWorkersEnv); maxWorkers != "" {
+		if pool.MaxWorkers, err = strconv.Atoi(maxWorkers); err != nil {
+			log.Fatalf("Invalid %s: %v", config.MaxWorkersEnv, err)
+		}
+	}
+	if err := syncEngine.SetPoolConfig(pool); err != nil {
+		log.Fatalf("Invalid worker pool bounds: %v", err)
+	}
+
+	syncEngine.SetNameProfiles(localNames, remoteNames)
+
+	// Symbolic links are skipped unless another policy is chosen
+	if policy := os.Getenv(config.SymlinkPolicyEnv); policy != "" {
+		if err := syncEngine.SetSymlinkPolicy(policy); err != nil {
+			log.Fatalf("Invalid %s: %v", config.SymlinkPolicyEnv, err)
+		}
+	}
+
+	// Priority rules move matching paths ahead of or behind other changes
+	if spec := os.Getenv(config.PriorityRulesEnv); spec != "" {
+		rules, err := engine.ParsePriorityRules(spec)
+		if err == nil {
+			err = syncEngine.SetPriorityRules(rules)
+		}
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", config.PriorityRulesEnv, err)
+		}
+	}
+
+	// "plan" prints what a sync would do without changing anything
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		plan, err := syncEngine.PlanSync()
+		if err != nil {
+			log.Fatalf("Failed to plan sync: %v", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			log.Fatalf("Failed to print sync plan: %v", err)
+		}
+		return
+	}
+
 	// Run sync engine
 	if err := syncEngine.Run(); err != nil {
 		log.Fatalf("Failed to run sync engine: %v", err)
@@ -36,7 +160,55 @@ func main() {
 
 	// Start API server
 	apiServer := api.NewServer(syncEngine)
-	if err := apiServer.Start(config.API_PORT); err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- apiServer.Start(config.API_PORT)
+	}()
+
+	// Run until SIGINT or SIGTERM; a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
+		syncEngine.Stop()
 		log.Fatalf("Failed to start API server: %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Close the API first so no request can start a sync, resume or restore
+	// while the engine drains
+	log.Println("Shutting down, waiting for transfers in progress...")
+	clean := true
+	apiCtx, cancelAPI := context.WithTimeout(context.Background(), config.APIShutdownTimeout)
+	defer cancelAPI()
+	if err := apiServer.Shutdown(apiCtx); err != nil {
+		log.Printf("API server did not stop cleanly: %v\n", err)
+		clean = false
+	}
+	engineCtx, cancelEngine := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelEngine()
+	if err := syncEngine.Shutdown(engineCtx); err != nil {
+		log.Printf("Sync engine did not stop cleanly: %v\n", err)
+		clean = false
+	}
+	if !clean {
+		cancelEngine()
+		cancelAPI()
+		os.Exit(1)
+	}
+	log.Println("Shutdown complete")
+}
+
+// Reads the naming rules of a root from the environment, defaulting to posix.
+func nameProfile(profileEnv, escapeEnv string) storage.NameProfile {
+	name := os.Getenv(profileEnv)
+	if name == "" {
+		name = config.DefaultNameProfile
+	}
+	profile, err := storage.ParseNameProfile(name)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", profileEnv, err)
 	}
+	profile.Escape = os.Getenv(escapeEnv) == "true"
+	return profile
 }
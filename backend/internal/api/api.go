@@ -1,9 +1,15 @@
 package api
 
 import (
+	"backend/internal/config"
 	"backend/internal/engine"
+	"backend/internal/storage"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,26 +23,34 @@ type Server struct {
 	clients  map[*websocket.Conn]bool
 	clientMu sync.RWMutex
 	upgrader websocket.Upgrader
-	events   chan SyncEvent
+	events   *engine.Subscription
 	router   *gin.Engine
-}
 
-// A sync event structure to send to clients
-type SyncEvent struct {
-	Type      string    `json:"type"`
-	FilePath  string    `json:"filePath"`
-	Direction string    `json:"direction"`
-	Timestamp time.Time `json:"timestamp"`
-	Message   string    `json:"message"`
+	serverMu   sync.Mutex
+	httpServer *http.Server
 }
 
 // JSON response used for status endpoint
 type StatusResponse struct {
-	Status      string `json:"status"`
-	LocalFiles  int    `json:"localFiles"`
-	RemoteFiles int    `json:"remoteFiles"`
-	IsRunning   bool   `json:"isRunning"`
-	IsPaused    bool   `json:"isPaused"`
+	Status          string `json:"status"`
+	LocalFiles      int    `json:"localFiles"`
+	RemoteFiles     int    `json:"remoteFiles"`
+	IsRunning       bool   `json:"isRunning"`
+	IsPaused        bool   `json:"isPaused"`
+	PauseReason     string `json:"pauseReason,omitempty"`
+	PendingChanges  int    `json:"pendingChanges"`
+	PendingFullSync bool   `json:"pendingFullSync,omitempty"`
+}
+
+// JSON request body for the restore endpoint
+type RestoreRequest struct {
+	VersionID string `json:"versionId"`
+}
+
+// JSON request body for the trash restore endpoint
+type TrashRestoreRequest struct {
+	ID       string `json:"id"`
+	Location string `json:"location"`
 }
 
 // JSON response used for pause/resume/manual sync endpoints
@@ -45,6 +59,19 @@ type SyncResponse struct {
 	Message string `json:"message"`
 }
 
+// JSON response used when a background job is started
+type JobResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	JobID   string `json:"jobId"`
+}
+
+// JSON response used for the rate limits endpoint
+type LimitsResponse struct {
+	Configured engine.RateLimitConfig `json:"configured"`
+	Effective  engine.RateLimits      `json:"effective"`
+}
+
 // Creates a new API server instance
 func NewServer(engine *engine.SyncEngine) *Server {
 	router := gin.New()
@@ -59,14 +86,14 @@ func NewServer(engine *engine.SyncEngine) *Server {
 				return true // Allow all origins for development
 			},
 		},
-		events: make(chan SyncEvent, 100),
+		events: engine.Subscribe("websocket", config.EventSubscriberBuffer),
 		router: router,
 	}
 
 	// CORS middleware
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusOK)
@@ -79,23 +106,36 @@ func NewServer(engine *engine.SyncEngine) *Server {
 	apiGroup := router.Group("/api")
 	apiGroup.GET("/status", server.handleStatus)
 	apiGroup.GET("/files", server.handleFiles)
+	apiGroup.GET("/files/*path", server.handleFileAction)
+	apiGroup.POST("/files/*path", server.handleFileAction)
 	apiGroup.POST("/pause", server.handlePause)
 	apiGroup.POST("/resume", server.handleResume)
 	apiGroup.POST("/sync", server.handleManualSync)
+	apiGroup.GET("/transfers", server.handleListTransfers)
+	apiGroup.GET("/limits", server.handleGetLimits)
+	apiGroup.PUT("/limits", server.handleSetLimits)
+	apiGroup.GET("/queue", server.handleQueueStatus)
+	apiGroup.GET("/pool", server.handlePoolStatus)
+	apiGroup.PUT("/pool", server.handleSetPoolConfig)
+	apiGroup.PUT("/queue/rules", server.handleSetPriorityRules)
+	apiGroup.GET("/jobs/:id", server.handleGetJob)
+	apiGroup.DELETE("/jobs/:id", server.handleCancelJob)
+	apiGroup.GET("/sync/schedule", server.handleSchedule)
+	apiGroup.POST("/sync/plan", server.handlePlanSync)
+	apiGroup.POST("/sync/plan/:id/apply", server.handleApplyPlan)
+	apiGroup.GET("/failures", server.handleListFailures)
+	apiGroup.POST("/failures", server.handleRetryFailures)
+	apiGroup.POST("/guard/confirm", server.handleConfirmDeletions)
+	apiGroup.POST("/guard/dismiss", server.handleDismissGuard)
+	apiGroup.GET("/trash", server.handleListTrash)
+	apiGroup.POST("/trash/restore", server.handleRestoreFromTrash)
+	apiGroup.DELETE("/trash", server.handleEmptyTrash)
+	apiGroup.GET("/storage/dedup", server.handleDedupStats)
+	apiGroup.POST("/storage/gc", server.handleCollectGarbage)
+	apiGroup.GET("/events/stats", server.handleEventStats)
 
 	router.GET("/ws", server.handleWebSocket)
 
-	// Register callback to receive sync events from engine
-	engine.SetEventCallback(func(eventType, filePath, direction, message string) {
-		server.NotifyEvent(SyncEvent{
-			Type:      eventType,
-			FilePath:  filePath,
-			Direction: direction,
-			Timestamp: time.Now(),
-			Message:   message,
-		})
-	})
-
 	return server
 }
 
@@ -104,18 +144,54 @@ func (s *Server) Start(port string) error {
 	// Start the event broadcasting goroutine
 	go s.broadcastEvents()
 
+	s.serverMu.Lock()
+	s.httpServer = &http.Server{Addr: ":" + port, Handler: s.router}
+	server := s.httpServer
+	s.serverMu.Unlock()
+
 	log.Printf("API server starting on port %s\n", port)
-	return s.router.Run(":" + port)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Closes WebSocket clients with a close frame and shuts down the HTTP
+// server, waiting for requests in progress until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.events.Close()
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	s.clientMu.Lock()
+	for client := range s.clients {
+		if err := client.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+			log.Printf("Error sending close frame to client: %v\n", err)
+		}
+		client.Close()
+		delete(s.clients, client)
+	}
+	s.clientMu.Unlock()
+
+	s.serverMu.Lock()
+	server := s.httpServer
+	s.serverMu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
 
 // Handler for /api/status endpoint
 func (s *Server) handleStatus(c *gin.Context) {
+	pending, fullSync := s.engine.PendingChanges()
 	status := StatusResponse{
-		Status:      "running",
-		LocalFiles:  s.engine.GetLocalFileCount(),
-		RemoteFiles: s.engine.GetRemoteFileCount(),
-		IsRunning:   true,
-		IsPaused:    s.engine.IsPaused(),
+		Status:          "running",
+		LocalFiles:      s.engine.GetLocalFileCount(),
+		RemoteFiles:     s.engine.GetRemoteFileCount(),
+		IsRunning:       true,
+		IsPaused:        s.engine.IsPaused(),
+		PauseReason:     s.engine.PauseReason(),
+		PendingChanges:  pending,
+		PendingFullSync: fullSync,
 	}
 
 	c.JSON(http.StatusOK, status)
@@ -123,10 +199,62 @@ func (s *Server) handleStatus(c *gin.Context) {
 
 // Handler for /api/files endpoint
 func (s *Server) handleFiles(c *gin.Context) {
-	files := s.engine.GetFileList()
+	files := s.engine.GetFileList(c.Query("includeDirs") == "true")
 	c.JSON(http.StatusOK, files)
 }
 
+// Dispatches /api/files/:path/versions and /api/files/:path/restore.
+// File paths contain slashes, so the action is taken from the path suffix.
+func (s *Server) handleFileAction(c *gin.Context) {
+	filePath := strings.TrimPrefix(c.Param("path"), "/")
+	switch {
+	case c.Request.Method == http.MethodGet && strings.HasSuffix(filePath, "/versions"):
+		s.handleListVersions(c, strings.TrimSuffix(filePath, "/versions"))
+	case c.Request.Method == http.MethodPost && strings.HasSuffix(filePath, "/restore"):
+		s.handleRestoreVersion(c, strings.TrimSuffix(filePath, "/restore"))
+	default:
+		c.JSON(http.StatusNotFound, SyncResponse{Success: false, Message: "Unknown file action"})
+	}
+}
+
+// Handler for /api/files/:path/versions endpoint
+func (s *Server) handleListVersions(c *gin.Context, filePath string) {
+	versions, err := s.engine.ListVersions(filePath)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrUnsafePath) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if versions == nil {
+		versions = []engine.FileVersion{}
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// Handler for /api/files/:path/restore endpoint
+func (s *Server) handleRestoreVersion(c *gin.Context, filePath string) {
+	var request RestoreRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.VersionID == "" {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: "versionId is required"})
+		return
+	}
+	if err := s.engine.RestoreVersion(filePath, request.VersionID); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, engine.ErrVersionNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, storage.ErrUnsafePath):
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "File restored: " + filePath})
+}
+
 // Handler for WebSocket connections
 func (s *Server) handleWebSocket(c *gin.Context) {
 	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -157,7 +285,7 @@ func (s *Server) handleWebSocket(c *gin.Context) {
 
 func (s *Server) broadcastEvents() {
 	// Continuously listen for events and broadcast to all connected clients
-	for event := range s.events {
+	for event := range s.events.Events() {
 		var clientsToRemove []*websocket.Conn
 		// Lock the clients map for reading
 		s.clientMu.RLock()
@@ -184,15 +312,6 @@ func (s *Server) broadcastEvents() {
 	}
 }
 
-// Sends a sync event to the event channel
-func (s *Server) NotifyEvent(event SyncEvent) {
-	select {
-	case s.events <- event:
-	default:
-		log.Println("Event channel full, dropping event")
-	}
-}
-
 // Handler for /api/pause endpoint
 func (s *Server) handlePause(c *gin.Context) {
 	s.engine.Pause()
@@ -206,7 +325,10 @@ func (s *Server) handlePause(c *gin.Context) {
 
 // Handler for /api/resume endpoint
 func (s *Server) handleResume(c *gin.Context) {
-	s.engine.Resume()
+	if err := s.engine.Resume(); err != nil {
+		c.JSON(http.StatusConflict, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
 
 	response := SyncResponse{
 		Success: true,
@@ -215,9 +337,70 @@ func (s *Server) handleResume(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// Handler for /api/sync/schedule endpoint
+func (s *Server) handleSchedule(c *gin.Context) {
+	c.JSON(http.StatusOK, s.engine.GetScheduleStatus())
+}
+
+// Handler for /api/sync/plan endpoint
+func (s *Server) handlePlanSync(c *gin.Context) {
+	plan, err := s.engine.PlanSync()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+// Handler for /api/sync/plan/:id/apply endpoint
+func (s *Server) handleApplyPlan(c *gin.Context) {
+	result, err := s.engine.ApplyPlan(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, engine.ErrPlanNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, engine.ErrGuardTripped):
+			status = http.StatusConflict
+		}
+		c.JSON(status, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Handler for GET /api/failures endpoint
+func (s *Server) handleListFailures(c *gin.Context) {
+	c.JSON(http.StatusOK, s.engine.ListFailures())
+}
+
+// Handler for POST /api/failures endpoint
+func (s *Server) handleRetryFailures(c *gin.Context) {
+	count := s.engine.RetryFailures()
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: fmt.Sprintf("Retrying %d failed paths", count)})
+}
+
+// Handler for /api/guard/confirm endpoint
+func (s *Server) handleConfirmDeletions(c *gin.Context) {
+	if err := s.engine.ConfirmDeletions(); err != nil {
+		c.JSON(http.StatusConflict, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Deletions confirmed, sync resumed"})
+}
+
+// Handler for /api/guard/dismiss endpoint
+func (s *Server) handleDismissGuard(c *gin.Context) {
+	if err := s.engine.DismissGuard(); err != nil {
+		c.JSON(http.StatusConflict, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Deletions discarded, missing files restored and sync resumed"})
+}
+
 // Handler for /api/sync endpoint
 func (s *Server) handleManualSync(c *gin.Context) {
-	err := s.engine.ManualSync()
+	job, err := s.engine.StartSyncJob()
 	if err != nil {
 		response := SyncResponse{
 			Success: false,
@@ -227,18 +410,157 @@ func (s *Server) handleManualSync(c *gin.Context) {
 		return
 	}
 
-	response := SyncResponse{
+	response := JobResponse{
 		Success: true,
-		Message: "Manual sync completed successfully",
+		Message: "Manual sync started",
+		JobID:   job.ID,
 	}
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusAccepted, response)
+}
 
-	// Notify connected clients
-	s.NotifyEvent(SyncEvent{
-		Type:      "sync",
-		FilePath:  "manual",
-		Direction: "both",
-		Timestamp: time.Now(),
-		Message:   "Manual sync triggered",
-	})
+// Handler for /api/transfers endpoint
+func (s *Server) handleListTransfers(c *gin.Context) {
+	c.JSON(http.StatusOK, s.engine.ListTransfers())
+}
+
+// Handler for GET /api/limits endpoint
+func (s *Server) handleGetLimits(c *gin.Context) {
+	configured, effective := s.engine.GetRateLimits()
+	c.JSON(http.StatusOK, LimitsResponse{Configured: configured, Effective: effective})
+}
+
+// Handler for PUT /api/limits endpoint
+func (s *Server) handleSetLimits(c *gin.Context) {
+	var limits engine.RateLimitConfig
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if err := s.engine.SetRateLimits(limits); err != nil {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Rate limits updated"})
+}
+
+// Handler for GET /api/queue endpoint
+func (s *Server) handleQueueStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, s.engine.GetQueueStatus())
+}
+
+// Handler for GET /api/pool endpoint
+func (s *Server) handlePoolStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, s.engine.GetPoolStatus())
+}
+
+// Handler for PUT /api/pool endpoint
+func (s *Server) handleSetPoolConfig(c *gin.Context) {
+	var pool engine.PoolConfig
+	if err := c.ShouldBindJSON(&pool); err != nil {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if err := s.engine.SetPoolConfig(pool); err != nil {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Worker pool bounds updated"})
+}
+
+// Handler for PUT /api/queue/rules endpoint
+func (s *Server) handleSetPriorityRules(c *gin.Context) {
+	var rules []engine.PriorityRule
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if err := s.engine.SetPriorityRules(rules); err != nil {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Priority rules updated"})
+}
+
+// Handler for GET /api/jobs/:id endpoint
+func (s *Server) handleGetJob(c *gin.Context) {
+	job, err := s.engine.GetSyncJob(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// Handler for DELETE /api/jobs/:id endpoint
+func (s *Server) handleCancelJob(c *gin.Context) {
+	if err := s.engine.CancelSyncJob(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Sync job cancellation requested"})
+}
+
+// Handler for GET /api/trash endpoint
+func (s *Server) handleListTrash(c *gin.Context) {
+	entries, err := s.engine.ListTrash()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []engine.TrashEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// Handler for /api/trash/restore endpoint
+func (s *Server) handleRestoreFromTrash(c *gin.Context) {
+	var request TrashRestoreRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.ID == "" || (request.Location != "local" && request.Location != "remote") {
+		c.JSON(http.StatusBadRequest, SyncResponse{Success: false, Message: "id and location (local or remote) are required"})
+		return
+	}
+	if err := s.engine.RestoreFromTrash(request.Location, request.ID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, engine.ErrTrashEntryNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "File restored from trash"})
+}
+
+// Handler for DELETE /api/trash endpoint
+func (s *Server) handleEmptyTrash(c *gin.Context) {
+	if err := s.engine.EmptyTrash(); err != nil {
+		c.JSON(http.StatusInternalServerError, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SyncResponse{Success: true, Message: "Trash emptied"})
+}
+
+// Handler for /api/storage/dedup endpoint
+func (s *Server) handleDedupStats(c *gin.Context) {
+	stats, err := s.engine.GetDedupStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// Handler for /api/storage/gc endpoint
+func (s *Server) handleCollectGarbage(c *gin.Context) {
+	reports, err := s.engine.CollectGarbage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, SyncResponse{Success: false, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// Handler for /api/events/stats endpoint
+func (s *Server) handleEventStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.engine.EventStats())
 }
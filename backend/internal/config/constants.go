@@ -4,8 +4,124 @@ import "time"
 
 const (
 	LOCAL_PATH              = "./local_data"
+	DefaultPairName         = "default"
 	REMOTE_PATH             = "./remote_data"
 	API_PORT                = "8080"
 	DefaultJobBufferSize    = 2048
 	DefaultDebounceInterval = 500 * time.Millisecond
+	DeltaMinFileSize        = 64 * 1024
+
+	// Retention of prior file versions kept in each root's .sync-versions folder.
+	DefaultVersionKeepLast      = 10
+	DefaultVersionKeepDailyDays = 30
+	DefaultVersionMaxTotalBytes = 1 << 30
+
+	// Deleted files stay in each root's .sync-trash folder for this long.
+	DefaultTrashRetention = 30 * 24 * time.Hour
+	TrashPurgeInterval    = time.Hour
+
+	// Deletions propagated within the window beyond these limits pause sync
+	// until confirmed. The percentage only applies past the minimum count.
+	DefaultMassDeleteWindow     = time.Minute
+	DefaultMassDeleteMaxCount   = 500
+	DefaultMassDeleteMaxPercent = 50
+	DefaultMassDeleteMinCount   = 5
+
+	// Events buffered per event subscriber; further events are dropped for a
+	// subscriber that falls behind.
+	EventSubscriberBuffer = 100
+
+	// Number of dry-run sync plans kept for applying by ID.
+	MaxStoredPlans = 20
+
+	// Failed events are retried with exponential backoff before being
+	// moved to the dead-letter list.
+	MaxRetryAttempts  = 6
+	RetryBaseDelay    = time.Second
+	RetryMaxDelay     = 5 * time.Minute
+	RetryPollInterval = 500 * time.Millisecond
+
+	// Changed paths remembered while paused; beyond this a full sync runs on resume.
+	MaxPausedEvents = 10000
+
+	// Periodic reconciliation catches changes the watcher missed. It is off
+	// unless an interval or cron expression is configured.
+	DefaultScheduleInterval = time.Duration(0)
+	DefaultScheduleJitter   = 5 * time.Minute
+
+	// Finished manual sync jobs kept for status queries.
+	MaxStoredJobs = 50
+
+	// Minimum time between progress events for the same job or transfer.
+	ProgressEventInterval = time.Second
+
+	// How often time-of-day rate limit windows are re-evaluated.
+	RateLimitRefreshInterval = 30 * time.Second
+
+	// Queued events are served by lane: metadata and small files first, large
+	// files in their own lane limited to a quarter of the workers. Low-priority
+	// events waiting past the aging threshold are served next.
+	SmallFileThreshold     = 1 << 20
+	LargeFileThreshold     = 64 << 20
+	LargeLaneWorkerDivisor = 4
+	QueueAgingThreshold    = 2 * time.Minute
+	QueueStatusPreview     = 10
+
+	// The worker pool starts at the CPU count and is resized by one worker per
+	// interval within these bounds. Growth that does not raise throughput by
+	// the minimum gain is undone and not retried until the cooldown passes.
+	DefaultMinWorkers     = 2
+	DefaultMaxWorkers     = 32
+	PoolAdjustInterval    = 5 * time.Second
+	PoolGrowthCooldown    = time.Minute
+	PoolMaxErrorRate      = 0.2
+	PoolMinThroughputGain = 0.05
+	MaxPoolDecisions      = 20
+
+	// Hashing goroutines used when scanning and comparing the two sides, and
+	// reconcile copies queued per worker at a time.
+	ScanHashWorkers         = 4
+	ReconcileQueuePerWorker = 2
+
+	// On SIGINT or SIGTERM transfers in progress get this long to finish before
+	// they are aborted; the HTTP server then gets its own grace period.
+	ShutdownTimeout    = 30 * time.Second
+	APIShutdownTimeout = 5 * time.Second
+
+	// Both roots are assumed to follow POSIX naming rules unless configured otherwise.
+	DefaultNameProfile = "posix"
+
+	// Environment variables that configure periodic reconciliation.
+	ScheduleIntervalEnv       = "SYNC_SCHEDULE_INTERVAL"
+	ScheduleCronEnv           = "SYNC_SCHEDULE_CRON"
+	ScheduleJitterEnv         = "SYNC_SCHEDULE_JITTER"
+	ScheduleFullScanWindowEnv = "SYNC_FULL_SCAN_WINDOW"
+
+	// Environment variable holding queue priority rules such as "docs/**=high,*.iso=low".
+	PriorityRulesEnv = "SYNC_PRIORITY_RULES"
+
+	// Environment variables bounding the adaptive worker pool.
+	MinWorkersEnv = "SYNC_MIN_WORKERS"
+	MaxWorkersEnv = "SYNC_MAX_WORKERS"
+
+	// Environment variable choosing how symbolic links are synced: skip, copy or follow.
+	SymlinkPolicyEnv = "SYNC_SYMLINK_POLICY"
+
+	// Environment variables naming the naming rules of each root (posix, macos
+	// or windows) and whether names those rules forbid are escaped.
+	LocalNameProfileEnv  = "SYNC_LOCAL_NAME_PROFILE"
+	RemoteNameProfileEnv = "SYNC_REMOTE_NAME_PROFILE"
+	LocalEscapeNamesEnv  = "SYNC_LOCAL_ESCAPE_NAMES"
+	RemoteEscapeNamesEnv = "SYNC_REMOTE_ESCAPE_NAMES"
+
+	// Environment variables that enable encryption of the remote side.
+	RemoteEncryptionPassphraseEnv = "SYNC_REMOTE_PASSPHRASE"
+	RemoteEncryptionKeyFileEnv    = "SYNC_REMOTE_KEY_FILE"
+	RemoteEncryptNamesEnv         = "SYNC_REMOTE_ENCRYPT_NAMES"
+
+	// Environment variable holding the gzip level (1-9) for remote compression.
+	RemoteCompressionLevelEnv = "SYNC_REMOTE_COMPRESSION_LEVEL"
+
+	// Environment variable that stores the remote side as a deduplicated chunk store.
+	RemoteChunkStoreEnv = "SYNC_REMOTE_CHUNK_STORE"
 )
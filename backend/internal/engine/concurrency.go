@@ -1,27 +1,39 @@
 package engine
 
 import (
+	"backend/internal/config"
+	"context"
+	"errors"
 	"log"
 	"sync"
 	"time"
 )
 
+// Returned when the engine stops while a reconciliation is in progress.
+var ErrEngineStopped = errors.New("sync engine stopped")
+
+// Reports the outcome of a reconcile copy run by a worker.
+type actionResult struct {
+	action *SyncAction
+	err    error
+}
+
 // Runs a worker goroutine to process events.
 func (s *SyncEngine) worker() {
 	defer s.workerWG.Done()
 	for {
+		qe, ok := s.jobs.pop()
+		if !ok {
+			return
+		}
 		select {
 		case <-s.stopCh:
+			s.jobs.done(qe)
 			return
-		case qe, ok := <-s.jobs:
-			if !ok {
-				return
-			}
-			if qe.raw.Name == "" {
-				continue
-			}
-			s.processEventWithLock(qe)
+		default:
 		}
+		s.processEventWithLock(qe)
+		s.jobs.done(qe)
 	}
 }
 
@@ -33,6 +45,10 @@ func (s *SyncEngine) lockFor(relPath string) *sync.Mutex {
 
 // Processes an event with a lock.
 func (s *SyncEngine) processEventWithLock(event queuedEvent) {
+	if event.action != nil {
+		s.processAction(event)
+		return
+	}
 	if event.raw.Name == "" {
 		return
 	}
@@ -50,11 +66,81 @@ func (s *SyncEngine) processEventWithLock(event queuedEvent) {
 			side = "local"
 		}
 		log.Printf("error handling %s event for %s (rel: %s): %v", side, event.raw.Name, event.relPath, err)
+		s.processedEvents.Add(1)
+		s.failedEvents.Add(1)
+		s.scheduleRetry(event, err)
+		return
+	}
+	s.processedEvents.Add(1)
+	s.processedBytes.Add(event.size)
+	s.clearRetry(event)
+}
+
+// Applies a reconcile copy under its file's lock and reports the outcome.
+func (s *SyncEngine) processAction(event queuedEvent) {
+	_, err := s.applyCurrentAction(*event.action)
+	s.processedEvents.Add(1)
+	if err != nil {
+		s.failedEvents.Add(1)
+	} else {
+		s.processedBytes.Add(event.size)
 	}
+	event.result <- actionResult{action: event.action, err: err}
 }
 
-// Handles a queued event.
+// Runs reconcile actions on the worker pool, keeping a few per worker queued
+// so watcher events still get through. Stops dispatching at the first error
+// or once ctx is cancelled, and waits for the actions already dispatched.
+func (s *SyncEngine) dispatchActions(ctx context.Context, actions []SyncAction, job *syncJob) error {
+	// Buffered for every action so workers never block reporting back.
+	results := make(chan actionResult, len(actions))
+	next, pending := 0, 0
+	var firstErr error
+	for next < len(actions) || pending > 0 {
+		window := s.GetPoolStatus().Workers * config.ReconcileQueuePerWorker
+		for firstErr == nil && ctx.Err() == nil && next < len(actions) && pending < window {
+			action := &actions[next]
+			s.jobs.pushAll([]queuedEvent{{
+				isLocal: action.Direction == getDirection(true),
+				relPath: action.RelativePath,
+				size:    action.Size,
+				lane:    s.laneFor(action.RelativePath, action.Size),
+				action:  action,
+				result:  results,
+			}})
+			next++
+			pending++
+		}
+		if pending == 0 {
+			break
+		}
+
+		select {
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				if firstErr == nil {
+					firstErr = result.err
+				}
+				continue
+			}
+			s.advanceJob(job, result.action.Size)
+		case <-s.stopCh:
+			return ErrEngineStopped
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Handles a queued event, deferring it until resume while sync is paused.
 func (s *SyncEngine) handleQueuedEvent(event queuedEvent) error {
+	if s.deferWhilePaused(event) {
+		log.Printf("Sync paused, deferring event for: %s\n", event.raw.Name)
+		return nil
+	}
 	return s.handleEvent(event.raw)
 }
 
@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	minDeltaBlockSize = 2 * 1024
+	maxDeltaBlockSize = 128 * 1024
+	maxDeltaLiteral   = 256 * 1024
+)
+
+// Holds rolling and strong checksums for each block of a basis file.
+type deltaSignature struct {
+	blockSize int
+	blocks    []blockSignature
+	weak      map[uint32][]int
+}
+
+// Describes a single block of the basis file.
+type blockSignature struct {
+	offset int64
+	size   int
+	strong [sha256.Size]byte
+}
+
+// Is either a reference to a basis block or a run of literal bytes.
+type deltaOp struct {
+	block   int
+	literal []byte
+}
+
+// Picks a block size of roughly the square root of the file size, like rsync.
+func deltaBlockSize(size int64) int {
+	block := int(math.Sqrt(float64(size)))
+	block = (block + 7) &^ 7
+	return min(max(block, minDeltaBlockSize), maxDeltaBlockSize)
+}
+
+// Computes block signatures of a basis file.
+func computeSignature(basis io.ReaderAt, size int64) (deltaSignature, error) {
+	sig := deltaSignature{
+		blockSize: deltaBlockSize(size),
+		weak:      make(map[uint32][]int),
+	}
+	buf := make([]byte, sig.blockSize)
+	for offset := int64(0); offset < size; offset += int64(sig.blockSize) {
+		n, err := basis.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return deltaSignature{}, fmt.Errorf("failed to read basis block at %d: %w", offset, err)
+		}
+		if n < sig.blockSize {
+			// A short final block can only match at the very end of the
+			// source, which the streaming delta does not look for.
+			break
+		}
+		weak := newRollingChecksum(buf[:n]).sum()
+		sig.weak[weak] = append(sig.weak[weak], len(sig.blocks))
+		sig.blocks = append(sig.blocks, blockSignature{offset: offset, size: n, strong: sha256.Sum256(buf[:n])})
+	}
+	return sig, nil
+}
+
+// Streams src against a basis signature, emitting block references for data
+// already present in the basis and literals for everything else.
+func generateDelta(src io.Reader, sig deltaSignature, emit func(deltaOp) error) error {
+	bs := sig.blockSize
+	buf := make([]byte, 0, 2*maxDeltaLiteral)
+	eof := false
+	// buf[litStart:pos] is pending literal data and buf[pos:pos+bs] the window.
+	pos, litStart := 0, 0
+
+	flushLiteral := func() error {
+		if pos == litStart {
+			return nil
+		}
+		literal := append([]byte(nil), buf[litStart:pos]...)
+		litStart = pos
+		return emit(deltaOp{block: -1, literal: literal})
+	}
+
+	var rolling *rollingChecksum
+	for {
+		// Keep one byte beyond the window available so it can roll.
+		if len(buf) < pos+bs+1 && !eof {
+			if len(buf) == cap(buf) {
+				if err := flushLiteral(); err != nil {
+					return err
+				}
+			}
+			n := copy(buf, buf[litStart:])
+			buf = buf[:n]
+			pos -= litStart
+			litStart = 0
+			for !eof && len(buf) < pos+bs+1 {
+				n, err := src.Read(buf[len(buf):cap(buf)])
+				buf = buf[:len(buf)+n]
+				if err == io.EOF {
+					eof = true
+				} else if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if pos+bs > len(buf) {
+			pos = len(buf)
+			return flushLiteral()
+		}
+
+		if rolling == nil {
+			rolling = newRollingChecksum(buf[pos : pos+bs])
+		}
+		if block, ok := sig.match(rolling.sum(), buf[pos:pos+bs]); ok {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+			if err := emit(deltaOp{block: block}); err != nil {
+				return err
+			}
+			pos += bs
+			litStart = pos
+			rolling = nil
+			continue
+		}
+		if pos+bs == len(buf) {
+			pos = len(buf)
+			return flushLiteral()
+		}
+
+		rolling.roll(buf[pos], buf[pos+bs])
+		pos++
+		if pos-litStart >= maxDeltaLiteral {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Looks up a window in the signature, confirming weak matches with SHA256.
+func (sig deltaSignature) match(weak uint32, window []byte) (int, bool) {
+	candidates, ok := sig.weak[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := sha256.Sum256(window)
+	for _, index := range candidates {
+		if sig.blocks[index].strong == strong {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// Rebuilds the new file from delta operations and the basis file.
+type deltaApplier struct {
+	basis io.ReaderAt
+	sig   deltaSignature
+	out   io.Writer
+	buf   []byte
+	saved int64
+}
+
+// Applies a single delta operation, tracking bytes reused from the basis.
+func (a *deltaApplier) apply(op deltaOp) error {
+	if op.block < 0 {
+		_, err := a.out.Write(op.literal)
+		return err
+	}
+	block := a.sig.blocks[op.block]
+	if cap(a.buf) < block.size {
+		a.buf = make([]byte, block.size)
+	}
+	data := a.buf[:block.size]
+	if _, err := a.basis.ReadAt(data, block.offset); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read basis block at %d: %w", block.offset, err)
+	}
+	if _, err := a.out.Write(data); err != nil {
+		return err
+	}
+	a.saved += int64(block.size)
+	return nil
+}
+
+// Implements the rsync weak checksum, which can slide one byte at a time.
+type rollingChecksum struct {
+	a, b uint32
+	size uint32
+}
+
+// Computes the checksum of an initial window.
+func newRollingChecksum(window []byte) *rollingChecksum {
+	r := &rollingChecksum{size: uint32(len(window))}
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+	return r
+}
+
+// Slides the window by dropping out and appending in.
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a = r.a - uint32(out) + uint32(in)
+	r.b = r.b - r.size*uint32(out) + r.a
+}
+
+// Returns the combined 32-bit checksum.
+func (r *rollingChecksum) sum() uint32 {
+	return (r.a & 0xffff) | (r.b << 16)
+}
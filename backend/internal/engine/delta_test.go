@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"backend/internal/storage"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Returns n pseudo-random bytes that are the same on every run.
+func randomBytes(seed uint64, n int) []byte {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	return data
+}
+
+// Rebuilds src from a delta against basis, returning the result and the
+// bytes reused from the basis.
+func roundTripDelta(t *testing.T, basis, src []byte) ([]byte, int64) {
+	t.Helper()
+	sig, err := computeSignature(bytes.NewReader(basis), int64(len(basis)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	applier := &deltaApplier{basis: bytes.NewReader(basis), sig: sig, out: &out}
+	if err := generateDelta(bytes.NewReader(src), sig, applier.apply); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), applier.saved
+}
+
+func TestRollingChecksumMatchesFreshWindow(t *testing.T) {
+	data := randomBytes(1, 10000)
+	for _, window := range []int{1, 7, minDeltaBlockSize} {
+		rolling := newRollingChecksum(data[:window])
+		for start := 1; start+window <= len(data); start++ {
+			rolling.roll(data[start-1], data[start+window-1])
+			if want := newRollingChecksum(data[start : start+window]).sum(); rolling.sum() != want {
+				t.Fatalf("window %d at %d: rolled %08x, fresh %08x", window, start, rolling.sum(), want)
+			}
+		}
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	basis := randomBytes(2, 300*1024)
+	insert := randomBytes(3, 5000)
+	splice := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name      string
+		basis     []byte
+		src       []byte
+		wantSaved bool
+	}{
+		{"identical", basis, basis, true},
+		{"insertion in the middle", basis, splice(basis[:100000], insert, basis[100000:]), true},
+		{"insertion at the start", basis, splice(insert, basis), true},
+		{"appended data", basis, splice(basis, insert), true},
+		{"deletion in the middle", basis, splice(basis[:50000], basis[90000:]), true},
+		{"truncated", basis, basis[:200000], true},
+		{"single byte changed", basis, splice(basis[:150000], []byte{^basis[150000]}, basis[150001:]), true},
+		{"unrelated content", basis, randomBytes(4, 400*1024), false},
+		{"literal longer than the limit", basis[:4096], randomBytes(5, 3*maxDeltaLiteral+123), false},
+		{"empty source", basis, nil, false},
+		{"empty basis", nil, insert, false},
+		{"both empty", nil, nil, false},
+		{"source shorter than a block", basis, basis[:100], false},
+		{"basis shorter than a block", basis[:100], basis[:5000], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, saved := roundTripDelta(t, tt.basis, tt.src)
+			if !bytes.Equal(got, tt.src) {
+				t.Fatalf("rebuilt %d bytes, want %d", len(got), len(tt.src))
+			}
+			if tt.wantSaved != (saved > 0) {
+				t.Errorf("reused %d bytes from the basis", saved)
+			}
+			if saved > int64(len(tt.src)) {
+				t.Errorf("reused %d bytes for a %d byte source", saved, len(tt.src))
+			}
+		})
+	}
+}
+
+func TestDeltaBlockSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, minDeltaBlockSize},
+		{1 << 20, 1024 * 2},
+		{1 << 30, 32 * 1024},
+		{1 << 40, maxDeltaBlockSize},
+	}
+	for _, tt := range tests {
+		if got := deltaBlockSize(tt.size); got != tt.want {
+			t.Errorf("deltaBlockSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+// Serves readers that fail after the first bytes of a file.
+type failingSource struct {
+	*storage.FileSystemProvider
+}
+
+func (p failingSource) GetReader(relativePath string) (io.ReadCloser, error) {
+	reader, err := p.FileSystemProvider.GetReader(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(io.LimitReader(reader, 1000), errReader{}), reader}, nil
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFailedCopyKeepsDestination(t *testing.T) {
+	srcRoot, dstRoot := t.TempDir(), t.TempDir()
+	src, err := storage.NewFileSystemProvider(srcRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := storage.NewFileSystemProvider(dstRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := randomBytes(6, 200*1024)
+	changed := append(randomBytes(7, 1000), original...)
+	if err := os.WriteFile(filepath.Join(dstRoot, "file.bin"), original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcRoot, "file.bin"), changed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fullCopyFile(failingSource{src}, dst, "file.bin", time.Now(), nil, nil); err == nil {
+		t.Fatal("full copy from a failing source succeeded")
+	}
+	if _, _, err := deltaCopyFile(failingSource{src}, dst, "file.bin", time.Now(), nil, nil); err == nil {
+		t.Fatal("delta copy from a failing source succeeded")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstRoot, "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, original) {
+		t.Fatalf("destination holds %d bytes after failed copies, want the original %d", len(got), len(original))
+	}
+	entries, err := os.ReadDir(dstRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("failed copies left %d entries behind", len(entries)-1)
+	}
+}
+
+func TestCopyFileAppliesAttributes(t *testing.T) {
+	srcRoot, dstRoot := t.TempDir(), t.TempDir()
+	src, err := storage.NewFileSystemProvider(srcRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := storage.NewFileSystemProvider(dstRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := randomBytes(8, 200*1024)
+	for _, mode := range []os.FileMode{0o751, 0o400} {
+		relPath := "file-" + mode.String()
+		if err := os.WriteFile(filepath.Join(srcRoot, relPath), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(srcRoot, relPath), mode); err != nil {
+			t.Fatal(err)
+		}
+		// The second copy finds a basis and goes through the delta path.
+		for range 2 {
+			if _, err := copyFile(src, dst, relPath, time.Now(), nil); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filepath.Join(dstRoot, relPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != mode {
+				t.Errorf("%s copied with mode %v", relPath, info.Mode().Perm())
+			}
+		}
+	}
+}
+
+func TestSyncEventReportsBytesSaved(t *testing.T) {
+	s := newTestEngine(t)
+	original := randomBytes(9, 300*1024)
+	writeEngineFile(t, s.localProvider, "file.bin", original)
+	writeEngineFile(t, s.remoteProvider, "file.bin", original)
+	if err := s.buildInitialState(); err != nil {
+		t.Fatal(err)
+	}
+	// Same size, so the event handler hashes both sides to compare them.
+	changed := bytes.Clone(original)
+	changed[150000] ^= 0xff
+	writeEngineFile(t, s.localProvider, "file.bin", changed)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(s.localProvider.GetPath(), "file.bin"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := s.Subscribe("test", 100)
+	defer sub.Close()
+	if err := s.handleWriteOrChmodEvent(fsnotify.Event{Op: fsnotify.Write}, true, "file.bin"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Kind != EventSync {
+				continue
+			}
+			if event.BytesSaved <= 0 || event.BytesSaved > int64(len(changed)) {
+				t.Errorf("sync event reports %d bytes saved", event.BytesSaved)
+			}
+			if event.DestinationHash != hashOf(changed) || event.SourceHash != event.DestinationHash {
+				t.Errorf("sync event reports source %s and destination %s", event.SourceHash, event.DestinationHash)
+			}
+			return
+		default:
+			t.Fatal("no sync event published")
+		}
+	}
+}
+
+// Returns the hash a provider reports for data.
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
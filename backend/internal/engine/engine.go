@@ -4,12 +4,16 @@ import (
 	"backend/internal/config"
 	"backend/internal/models"
 	"backend/internal/storage"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -23,14 +27,16 @@ type SyncEngine struct {
 	localMap  map[string]models.FileMetadata
 	remoteMap map[string]models.FileMetadata
 
-	watcher       *fsnotify.Watcher
-	mu            sync.RWMutex
-	isPaused      bool
-	pauseMu       sync.RWMutex
-	eventCallback func(eventType, filePath, direction, message string)
+	watcher        *fsnotify.Watcher
+	mu             sync.RWMutex
+	isPaused       bool
+	pausedEvents   map[string]queuedEvent
+	pausedOverflow bool
+	pauseMu        sync.RWMutex
+	events         *EventBus
+	pairName       string
 
-	jobs             chan queuedEvent
-	workerCount      int
+	jobs             *jobQueue
 	perFileLocks     sync.Map
 	debounceMu       sync.Mutex
 	pendingEvents    map[string]time.Time
@@ -39,18 +45,80 @@ type SyncEngine struct {
 	watcherWG        sync.WaitGroup
 	stopCh           chan struct{}
 	stopOnce         sync.Once
+	abortCh          chan struct{}
+	abortOnce        sync.Once
+
+	symlinkPolicy string
+	localNames    storage.NameProfile
+	remoteNames   storage.NameProfile
+
+	versionMu        sync.Mutex
+	versionRetention VersionRetention
+	versionIndexes   map[storage.StorageProvider]*versionIndex
+
+	trashMu        sync.Mutex
+	trashRetention time.Duration
+	backgroundMu   sync.Mutex
+	backgroundWG   sync.WaitGroup
+
+	guard deletionGuard
+
+	planMu   sync.Mutex
+	plans    map[string]*SyncPlan
+	lastPlan atomic.Uint64
+
+	retryMu     sync.Mutex
+	retries     map[string]*pendingRetry
+	deadLetters map[string]FailedPath
+
+	syncMu          sync.Mutex
+	scheduleMu      sync.Mutex
+	schedule        SyncSchedule
+	scheduleStatus  ScheduleStatus
+	scheduleChanged chan struct{}
+
+	syncJobsMu  sync.Mutex
+	syncJobs    map[string]*syncJob
+	lastSyncJob atomic.Uint64
+
+	transfersMu  sync.Mutex
+	transfers    map[string]*transfer
+	lastTransfer atomic.Uint64
+
+	limitsMu     sync.Mutex
+	limitConfig  RateLimitConfig
+	limitWindows []*timeWindow
+	limiters     rateLimiters
+
+	priorityMu    sync.RWMutex
+	priorityRules []PriorityRule
+
+	poolMu          sync.Mutex
+	pool            workerPool
+	processedEvents atomic.Int64
+	failedEvents    atomic.Int64
+	processedBytes  atomic.Int64
 }
 
 // Represents a file system event queued for processing.
 type queuedEvent struct {
-	raw     fsnotify.Event
-	isLocal bool
-	relPath string
+	raw        fsnotify.Event
+	isLocal    bool
+	relPath    string
+	size       int64
+	lane       string
+	enqueuedAt time.Time
+
+	// Set for reconcile copies, which report back on result instead of
+	// going through the event handlers.
+	action *SyncAction
+	result chan<- actionResult
 }
 
 // Represents information about a synchronized file.
 type FileInfo struct {
 	RelativePath string `json:"relativePath"`
+	Kind         string `json:"kind"`
 	Hash         string `json:"hash"`
 	ModTime      string `json:"modTime"`
 	Location     string `json:"location"`
@@ -72,21 +140,60 @@ func NewSyncEngine(localProvider storage.StorageProvider, remoteProvider storage
 	}
 
 	return &SyncEngine{
-		localProvider:  localProvider,
-		remoteProvider: remoteProvider,
-		watcher:        watcher,
-		localMap:       make(map[string]models.FileMetadata),
-		remoteMap:      make(map[string]models.FileMetadata),
-		jobs:           make(chan queuedEvent, config.DefaultJobBufferSize),
-		workerCount:    wc,
-		pendingEvents:  make(map[string]time.Time),
-		stopCh:         make(chan struct{}),
+		localProvider:    localProvider,
+		remoteProvider:   remoteProvider,
+		watcher:          watcher,
+		localMap:         make(map[string]models.FileMetadata),
+		remoteMap:        make(map[string]models.FileMetadata),
+		jobs:             newJobQueue(config.DefaultJobBufferSize, largeLaneSlots(wc)),
+		pendingEvents:    make(map[string]time.Time),
+		stopCh:           make(chan struct{}),
+		abortCh:          make(chan struct{}),
+		events:           NewEventBus(),
+		pairName:         config.DefaultPairName,
+		symlinkPolicy:    storage.SymlinkSkip,
+		localNames:       storage.NameProfile{Name: config.DefaultNameProfile},
+		remoteNames:      storage.NameProfile{Name: config.DefaultNameProfile},
+		versionRetention: DefaultVersionRetention(),
+		versionIndexes:   make(map[storage.StorageProvider]*versionIndex),
+		trashRetention:   config.DefaultTrashRetention,
+		pausedEvents:     make(map[string]queuedEvent),
+		plans:            make(map[string]*SyncPlan),
+		retries:          make(map[string]*pendingRetry),
+		deadLetters:      make(map[string]FailedPath),
+		schedule: SyncSchedule{
+			Interval: config.DefaultScheduleInterval,
+			Jitter:   config.DefaultScheduleJitter,
+		},
+		scheduleChanged: make(chan struct{}, 1),
+		syncJobs:        make(map[string]*syncJob),
+		transfers:       make(map[string]*transfer),
+		limiters:        newRateLimiters(),
+		pool: workerPool{
+			config:  DefaultPoolConfig(),
+			workers: wc,
+		},
+		guard: deletionGuard{
+			config: DefaultDeletionGuardConfig(),
+			recent: make(map[bool][]deletionRecord),
+		},
 	}, nil
 }
 
-// Sets a callback function to be called on sync events.
-func (s *SyncEngine) SetEventCallback(callback func(eventType, filePath, direction, message string)) {
-	s.eventCallback = callback
+// Sets how symbolic links under both roots are treated. Must be called
+// before Run.
+func (s *SyncEngine) SetSymlinkPolicy(policy string) error {
+	policy, err := storage.ParseSymlinkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	s.symlinkPolicy = policy
+	for _, provider := range []storage.StorageProvider{s.localProvider, s.remoteProvider} {
+		if setter, ok := provider.(storage.SymlinkPolicySetter); ok {
+			setter.SetSymlinkPolicy(policy)
+		}
+	}
+	return nil
 }
 
 // Starts the synchronization engine.
@@ -97,37 +204,95 @@ func (s *SyncEngine) Run() error {
 	if err := s.buildInitialState(); err != nil {
 		return err
 	}
+
+	// Reconcile copies run on the workers
+	s.startWorkers()
 	if err := s.reconcile(); err != nil {
+		s.Stop()
 		return err
 	}
 
-	for i := 0; i < s.workerCount; i++ {
-		s.workerWG.Add(1)
-		go s.worker()
-	}
-
 	if err := s.startWatcher(); err != nil {
 		s.Stop()
 		return err
 	}
+
+	s.backgroundWG.Add(5)
+	go s.runTrashPurger()
+	go s.runRetryScheduler()
+	go s.runScheduler()
+	go s.runRateLimitScheduler()
+	go s.runPoolController()
 	return nil
 }
 
 // Signals the engine to shut down and waits for workers to finish.
 func (s *SyncEngine) Stop() {
 	s.stopOnce.Do(func() {
+		// No background work starts once stopCh is closed.
+		s.backgroundMu.Lock()
 		close(s.stopCh)
+		s.backgroundMu.Unlock()
 		if s.watcher != nil {
 			if err := s.watcher.Close(); err != nil {
 				log.Printf("watcher close error: %v\n", err)
 			}
 		}
 		s.watcherWG.Wait()
-		close(s.jobs)
+		s.backgroundWG.Wait()
+		s.jobs.close()
 		s.workerWG.Wait()
 	})
 }
 
+// Runs fn in a background goroutine that Stop waits for. Fails with
+// ErrEngineStopped once the engine is stopping.
+func (s *SyncEngine) goBackground(fn func()) error {
+	s.backgroundMu.Lock()
+	defer s.backgroundMu.Unlock()
+	select {
+	case <-s.stopCh:
+		return ErrEngineStopped
+	default:
+	}
+	s.backgroundWG.Add(1)
+	go func() {
+		defer s.backgroundWG.Done()
+		fn()
+	}()
+	return nil
+}
+
+// Stops the engine gracefully: no new events are accepted and transfers in
+// progress may finish until ctx is done, after which they are aborted
+// without leaving partial files. Reports an error when transfers had to be
+// aborted.
+func (s *SyncEngine) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("Shutdown timed out, aborting transfers in progress")
+		s.abortOnce.Do(func() { close(s.abortCh) })
+		<-stopped
+		err = fmt.Errorf("%w: %v", ErrTransferAborted, ctx.Err())
+	}
+
+	if queued := s.jobs.status().TotalQueued; queued > 0 {
+		log.Printf("%d queued changes left for the next startup reconciliation\n", queued)
+	}
+	if retries := s.PendingRetryCount(); retries > 0 {
+		log.Printf("%d pending retries left for the next startup reconciliation\n", retries)
+	}
+	return err
+}
+
 // Starts the file system watcher to monitor changes.
 func (s *SyncEngine) startWatcher() error {
 	log.Println("Starting file system watcher...")
@@ -155,10 +320,9 @@ func (s *SyncEngine) startWatcher() error {
 				if !ok {
 					continue
 				}
-				select {
-				case s.jobs <- qe:
-				default:
-					log.Println("Job channel full, dropping event:", event)
+				s.classify(&qe)
+				if !s.jobs.push(qe) {
+					log.Println("Job queue full, dropping event:", event)
 				}
 			case err, ok := <-s.watcher.Errors:
 				if !ok {
@@ -181,8 +345,13 @@ func (s *SyncEngine) categorizeEvent(event fsnotify.Event) (queuedEvent, bool) {
 		return queuedEvent{}, false
 	}
 
+	// Hidden files are skipped by BuildStateMap and include in-progress writes.
+	if strings.HasPrefix(filepath.Base(event.Name), ".") {
+		return queuedEvent{}, false
+	}
+
 	isLocal, rel := s.whichSideAndRel(event.Name)
-	if rel == "" {
+	if rel == "" || isHiddenPath(rel) {
 		return queuedEvent{}, false
 	}
 
@@ -194,47 +363,73 @@ func (s *SyncEngine) categorizeEvent(event fsnotify.Event) (queuedEvent, bool) {
 	return qe, true
 }
 
-// Adds a path and its subdirectories to the watcher.
+// Adds a path and its subdirectories to the watcher. Links are never
+// followed: a directory watched through a link takes over the events of its
+// real path, and followed links only lead to directories inside the root,
+// which are watched under their real path already.
 func (s *SyncEngine) addWatcherPath(rootPath string) error {
-	return filepath.WalkDir(rootPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
+	s.watchDirectory(rootPath)
+	return storage.WalkTree(rootPath, storage.SymlinkSkip, func(path string, info os.FileInfo) error {
+		if !info.IsDir() {
+			return nil
 		}
-		if d.IsDir() {
-			if err := s.watcher.Add(path); err != nil {
-				log.Printf("Error adding path %s to watcher: %v\n", path, err)
-			} else {
-				log.Printf("Watching directory: %s\n", path)
-			}
+		if strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
 		}
+		s.watchDirectory(path)
 		return nil
 	})
 }
 
+// Adds a single directory to the watcher.
+func (s *SyncEngine) watchDirectory(path string) {
+	if err := s.watcher.Add(path); err != nil {
+		log.Printf("Error adding path %s to watcher: %v\n", path, err)
+	} else {
+		log.Printf("Watching directory: %s\n", path)
+	}
+}
+
 // Returns the count of files in the local storage.
 func (s *SyncEngine) GetLocalFileCount() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.localMap)
+	return countFiles(s.localMap)
 }
 
 // Returns the count of files in the remote storage.
 func (s *SyncEngine) GetRemoteFileCount() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.remoteMap)
+	return countFiles(s.remoteMap)
 }
 
-// Returns a list of all synchronized files with their details.
-func (s *SyncEngine) GetFileList() []FileInfo {
+// Counts the entries of a state map that are not directories.
+func countFiles(stateMap map[string]models.FileMetadata) int {
+	count := 0
+	for _, meta := range stateMap {
+		if !meta.IsDir() {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns a list of all synchronized files with their details, including
+// directories when requested.
+func (s *SyncEngine) GetFileList(includeDirs bool) []FileInfo {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	fileSet := make(map[string]FileInfo)
 
 	for relPath, meta := range s.localMap {
+		if meta.IsDir() && !includeDirs {
+			continue
+		}
 		fileSet[relPath] = FileInfo{
 			RelativePath: relPath,
+			Kind:         entryKind(meta),
 			Hash:         meta.Hash,
 			ModTime:      meta.ModTime.Format("YYYY/MM/DD HH:MM:SS"),
 			Location:     "local",
@@ -242,14 +437,18 @@ func (s *SyncEngine) GetFileList() []FileInfo {
 	}
 
 	for relPath, meta := range s.remoteMap {
+		if meta.IsDir() && !includeDirs {
+			continue
+		}
 		if existing, exists := fileSet[relPath]; exists {
-			if existing.Hash == meta.Hash {
+			if same, _ := s.localMap[relPath].Matches(meta); same {
 				existing.Location = "both"
 				fileSet[relPath] = existing
 			}
 		} else {
 			fileSet[relPath] = FileInfo{
 				RelativePath: relPath,
+				Kind:         entryKind(meta),
 				Hash:         meta.Hash,
 				ModTime:      meta.ModTime.Format("YYYY/MM/DD HH:MM:SS"),
 				Location:     "remote",
@@ -265,6 +464,48 @@ func (s *SyncEngine) GetFileList() []FileInfo {
 	return files
 }
 
+// Returns the kind of a state map entry, naming files explicitly.
+func entryKind(meta models.FileMetadata) string {
+	if meta.IsFile() {
+		return models.KindFile
+	}
+	return meta.Kind
+}
+
+// Returns deduplication statistics for each side backed by a deduplicating provider.
+func (s *SyncEngine) GetDedupStats() (map[string]storage.DedupStats, error) {
+	stats := make(map[string]storage.DedupStats)
+	for side, provider := range s.providersBySide() {
+		dedup, ok := provider.(storage.DedupProvider)
+		if !ok {
+			continue
+		}
+		sideStats, err := dedup.DedupStats()
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect %s dedup stats: %w", side, err)
+		}
+		stats[side] = sideStats
+	}
+	return stats, nil
+}
+
+// Removes unreferenced chunks from each side backed by a deduplicating provider.
+func (s *SyncEngine) CollectGarbage() (map[string]storage.GCReport, error) {
+	reports := make(map[string]storage.GCReport)
+	for side, provider := range s.providersBySide() {
+		dedup, ok := provider.(storage.DedupProvider)
+		if !ok {
+			continue
+		}
+		report, err := dedup.CollectGarbage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect %s garbage: %w", side, err)
+		}
+		reports[side] = report
+	}
+	return reports, nil
+}
+
 // Pauses the synchronization engine.
 func (s *SyncEngine) Pause() {
 	s.pauseMu.Lock()
@@ -274,11 +515,18 @@ func (s *SyncEngine) Pause() {
 }
 
 // Resumes the synchronization engine.
-func (s *SyncEngine) Resume() {
+// Fails while the mass-deletion guard is waiting for confirmation.
+func (s *SyncEngine) Resume() error {
 	s.pauseMu.Lock()
-	defer s.pauseMu.Unlock()
-	s.isPaused = false
+	if s.guard.tripped {
+		s.pauseMu.Unlock()
+		return ErrGuardTripped
+	}
+	events, overflow := s.unpauseLocked()
+	s.pauseMu.Unlock()
 	log.Println("Sync engine resumed")
+	s.replayPaused(events, overflow)
+	return nil
 }
 
 // Checks if the synchronization engine is paused.
@@ -289,89 +537,176 @@ func (s *SyncEngine) IsPaused() bool {
 }
 
 // Manually triggers a synchronization process.
+// Waits for a scheduled sync that is already running.
 func (s *SyncEngine) ManualSync() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
 
 	log.Println("Starting manual sync...")
+	if err := s.runSync(false); err != nil {
+		return err
+	}
+	log.Println("Manual sync completed successfully")
+	return nil
+}
+
+// Rebuilds the state maps and reconciles both sides. A full sync rehashes
+// every file present on both sides instead of trusting size and mod time.
+// Callers must hold s.syncMu.
+func (s *SyncEngine) runSync(full bool) error {
+	return s.runSyncJob(context.Background(), full, nil)
+}
 
+// Runs a sync, reporting progress to job when it is not nil and stopping
+// between files once ctx is cancelled.
+// Callers must hold s.syncMu.
+func (s *SyncEngine) runSyncJob(ctx context.Context, full bool, job *syncJob) error {
+	if s.IsGuardTripped() {
+		return ErrGuardTripped
+	}
+	s.setJobPhase(job, PhaseScanning)
 	if err := s.buildInitialState(); err != nil {
 		return fmt.Errorf("failed to rebuild state: %w", err)
 	}
-	if err := s.reconcile(); err != nil {
+	if full {
+		if err := s.rehashShared(); err != nil {
+			return fmt.Errorf("failed to rehash files: %w", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.reconcileJob(ctx, job); err != nil {
 		return fmt.Errorf("failed to reconcile: %w", err)
 	}
-
-	log.Println("Manual sync completed successfully")
 	return nil
 }
 
-// Handles missing files.
+// Handles missing files and directories.
 func (s *SyncEngine) handleMissingFile(relPath string, isLocal bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.handleMissingFileLocked(relPath, isLocal)
+}
 
+// Handles missing files and directories like handleMissingFile.
+// Callers must hold s.mu.
+func (s *SyncEngine) handleMissingFileLocked(relPath string, isLocal bool) {
 	_, dstProvider := s.getProviders(isLocal)
 	srcMap, dstMap := s.getStateMaps(isLocal)
 
-	delete(*srcMap, relPath)
-	delete(*dstMap, relPath)
+	if !s.allowDeletion(isLocal, relPath, *srcMap) {
+		log.Printf("Deletion of %s held back by mass-deletion guard\n", relPath)
+		return
+	}
 
-	if err := dstProvider.DeleteFile(relPath); err != nil && !os.IsNotExist(err) {
-		log.Printf("error deleting file %s: %v\n", relPath, err)
+	label := entryLabel((*srcMap)[relPath])
+	forgetTree(*srcMap, relPath)
+	forgetTree(*dstMap, relPath)
+
+	if err := s.moveToTrash(dstProvider, relPath); err != nil {
+		log.Printf("error deleting %s %s: %v\n", strings.ToLower(label), relPath, err)
 	}
 
-	if s.eventCallback != nil {
-		direction := getDirection(isLocal)
-		s.eventCallback("delete", relPath, direction, fmt.Sprintf("File deleted: %s", relPath))
+	s.publish(Event{
+		Kind:      EventDelete,
+		Path:      relPath,
+		Direction: getDirection(isLocal),
+		Message:   fmt.Sprintf("%s deleted: %s", label, relPath),
+	})
+}
+
+// Returns "Directory", "Link" or "File" for use in messages about an entry.
+func entryLabel(meta models.FileMetadata) string {
+	switch {
+	case meta.IsDir():
+		return "Directory"
+	case meta.IsSymlink():
+		return "Link"
 	}
+	return "File"
 }
 
-// Synchronizes a directory.
+// Creates a directory on the other side and records it in both state maps.
 func (s *SyncEngine) syncDirectory(relPath string, isLocal bool) error {
-	_, dstProvider := s.getProviders(isLocal)
+	srcProvider, dstProvider := s.getProviders(isLocal)
 	srcMap, dstMap := s.getStateMaps(isLocal)
 
+	meta, err := srcProvider.Stat(relPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat directory %s: %w", relPath, err)
+	}
+	s.mu.RLock()
+	err = s.checkName(relPath, isLocal, *dstMap)
+	s.mu.RUnlock()
+	if err != nil {
+		return s.reportNameConflict(relPath, isLocal, err)
+	}
 	if err := dstProvider.EnsureDir(relPath); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", relPath, err)
 	}
 
-	now := time.Now()
-	meta := models.FileMetadata{RelativePath: relPath, Hash: "", ModTime: now}
+	s.mu.Lock()
 	(*srcMap)[relPath] = meta
 	(*dstMap)[relPath] = meta
+	s.mu.Unlock()
 
-	if s.eventCallback != nil {
-		direction := getDirection(isLocal)
-		s.eventCallback("sync", relPath, direction, fmt.Sprintf("Directory synced: %s", relPath))
-	}
+	s.publish(Event{
+		Kind:      EventSync,
+		Path:      relPath,
+		Direction: getDirection(isLocal),
+		Message:   fmt.Sprintf("Directory synced: %s", relPath),
+	})
 
 	return nil
 }
 
-// Synchronizes a file.
+// Synchronizes a file, holding s.mu only around state map access.
 func (s *SyncEngine) syncFile(event fsnotify.Event, isLocal bool, relPath string) error {
 	srcProvider, dstProvider := s.getProviders(isLocal)
 	srcMap, dstMap := s.getStateMaps(isLocal)
 
-	srcMeta, err := srcProvider.GetMetadata(relPath)
+	srcMeta, err := srcProvider.Stat(relPath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			log.Printf("File %s no longer exists\n", event.Name)
 			s.handleMissingFile(relPath, isLocal)
 			return nil
 		}
+		if errors.Is(err, storage.ErrSymlinkSkipped) {
+			log.Printf("Ignoring %s: %v\n", event.Name, err)
+			return nil
+		}
 		return fmt.Errorf("error getting metadata for %s: %w", event.Name, err)
 	}
+	if srcMeta.IsDir() {
+		return s.syncDirectory(relPath, isLocal)
+	}
 
+	s.mu.RLock()
+	srcMeta = reuseHash(srcMeta, *srcMap)
 	dstMeta, existsInDst := (*dstMap)[relPath]
-	if existsInDst && srcMeta.Hash == dstMeta.Hash {
-		(*srcMap)[relPath] = srcMeta
-		(*dstMap)[relPath] = dstMeta
-		return nil
+	s.mu.RUnlock()
+	if existsInDst {
+		same, err := contentMatches(srcProvider, dstProvider, &srcMeta, &dstMeta)
+		if err != nil {
+			return fmt.Errorf("error comparing %s: %w", event.Name, err)
+		}
+		if same && !srcMeta.Attrs.Matches(dstMeta.Attrs) {
+			return s.syncAttributes(dstProvider, srcMap, dstMap, relPath, srcMeta, dstMeta, isLocal)
+		}
+		if same {
+			s.mu.Lock()
+			(*srcMap)[relPath] = srcMeta
+			(*dstMap)[relPath] = dstMeta
+			s.mu.Unlock()
+			return nil
+		}
 	}
 
 	if !existsInDst || srcMeta.ModTime.After(dstMeta.ModTime) {
 		return s.syncFileToDestination(srcProvider, dstProvider, srcMap, dstMap, relPath, srcMeta, isLocal)
 	}
 
-	return s.handleFileConflict(relPath, isLocal)
+	return s.handleFileConflict(relPath, srcMeta, dstMeta, isLocal)
 }
@@ -3,20 +3,18 @@ package engine
 import (
 	"backend/internal/models"
 	"backend/internal/storage"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
 // Processes a file system event.
 func (s *SyncEngine) handleEvent(event fsnotify.Event) error {
-	if s.IsPaused() {
-		log.Printf("Sync paused, ignoring event for: %s\n", event.Name)
-		return nil
-	}
-
 	isLocal, relPath, err := s.determineEventSource(event.Name)
 	if err != nil {
 		log.Printf("Error determining event source: %v\n", err)
@@ -37,14 +35,21 @@ func (s *SyncEngine) handleEvent(event fsnotify.Event) error {
 
 // Processes file/directory creation events.
 func (s *SyncEngine) handleCreateEvent(event fsnotify.Event, isLocal bool, relPath string) error {
-	info, err := os.Stat(event.Name)
+	srcProvider, _ := s.getProviders(isLocal)
+	meta, err := srcProvider.Stat(relPath)
+	if errors.Is(err, storage.ErrSymlinkSkipped) {
+		log.Printf("Ignoring %s: %v\n", event.Name, err)
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to stat %s: %w", event.Name, err)
 	}
 
-	if info.IsDir() {
+	if meta.IsDir() {
 		log.Printf("Directory created: %s\n", event.Name)
-		s.watcher.Add(event.Name)
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			s.watcher.Add(event.Name)
+		}
 		return s.syncDirectory(relPath, isLocal)
 	}
 
@@ -56,62 +61,88 @@ func (s *SyncEngine) handleDeleteOrRenameEvent(event fsnotify.Event, isLocal boo
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	eventType := "delete"
-	message := fmt.Sprintf("File deleted: %s", relPath)
-	if event.Op&fsnotify.Rename == fsnotify.Rename {
-		eventType = "move"
-		message = fmt.Sprintf("File moved or renamed: %s", relPath)
-	}
-
 	// Determine providers
 	_, dstProvider := s.getProviders(isLocal)
 	srcMap, dstMap := s.getStateMaps(isLocal)
 
-	// Remove from state maps
-	delete(*srcMap, relPath)
-	delete(*dstMap, relPath)
+	label := entryLabel((*srcMap)[relPath])
+	kind := EventDelete
+	message := fmt.Sprintf("%s deleted: %s", label, relPath)
+	if event.Op&fsnotify.Rename == fsnotify.Rename {
+		kind = EventMove
+		message = fmt.Sprintf("%s moved or renamed: %s", label, relPath)
+	}
 
-	// Delete from destination
-	if err := dstProvider.DeleteFile(relPath); err != nil && !os.IsNotExist(err) {
-		log.Printf("error deleting file %s: %v\n", relPath, err)
+	// Hold back deletions that look like a vanished mount
+	if !s.allowDeletion(isLocal, relPath, *srcMap) {
+		log.Printf("Deletion of %s held back by mass-deletion guard\n", relPath)
+		return nil
 	}
 
-	// Notify callback
-	if s.eventCallback != nil {
-		direction := getDirection(isLocal)
-		s.eventCallback(eventType, relPath, direction, message)
+	// Remove from state maps, including the contents of a directory
+	forgetTree(*srcMap, relPath)
+	forgetTree(*dstMap, relPath)
+
+	// Move to the destination's trash
+	if err := s.moveToTrash(dstProvider, relPath); err != nil {
+		log.Printf("error deleting %s %s: %v\n", strings.ToLower(label), relPath, err)
 	}
 
+	// Notify subscribers
+	s.publish(Event{Kind: kind, Path: relPath, Direction: getDirection(isLocal), Message: message})
+
 	return nil
 }
 
-// Processes file modification events.
+// Processes file modification events. Like applyAction it holds s.mu only
+// around state map access; the file's lock keeps other changes to the same
+// path out while it copies.
 func (s *SyncEngine) handleWriteOrChmodEvent(event fsnotify.Event, isLocal bool, relPath string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	// Determine providers
 	srcProvider, dstProvider := s.getProviders(isLocal)
 	srcMap, dstMap := s.getStateMaps(isLocal)
 
 	// Get source metadata
-	srcMeta, err := srcProvider.GetMetadata(relPath)
+	srcMeta, err := srcProvider.Stat(relPath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			log.Printf("File %s no longer exists\n", event.Name)
 			s.handleMissingFile(relPath, isLocal)
 			return nil
 		}
+		if errors.Is(err, storage.ErrSymlinkSkipped) {
+			log.Printf("Ignoring %s: %v\n", event.Name, err)
+			return nil
+		}
 		return fmt.Errorf("error getting metadata for %s: %w", event.Name, err)
 	}
 
+	// Directories are synced when created; later changes to them are not
+	if srcMeta.IsDir() {
+		return nil
+	}
+
 	// Check if file exists in destination
+	s.mu.RLock()
+	srcMeta = reuseHash(srcMeta, *srcMap)
 	dstMeta, existsInDst := (*dstMap)[relPath]
-	if existsInDst && srcMeta.Hash == dstMeta.Hash {
-		// Update state maps and return
-		(*srcMap)[relPath] = srcMeta
-		(*dstMap)[relPath] = dstMeta
-		return nil
+	s.mu.RUnlock()
+	if existsInDst {
+		same, err := contentMatches(srcProvider, dstProvider, &srcMeta, &dstMeta)
+		if err != nil {
+			return fmt.Errorf("error comparing %s: %w", event.Name, err)
+		}
+		if same && !srcMeta.Attrs.Matches(dstMeta.Attrs) {
+			return s.syncAttributes(dstProvider, srcMap, dstMap, relPath, srcMeta, dstMeta, isLocal)
+		}
+		if same {
+			// Update state maps and return
+			s.mu.Lock()
+			(*srcMap)[relPath] = srcMeta
+			(*dstMap)[relPath] = dstMeta
+			s.mu.Unlock()
+			return nil
+		}
 	}
 
 	// Handle file synchronization
@@ -120,7 +151,7 @@ func (s *SyncEngine) handleWriteOrChmodEvent(event fsnotify.Event, isLocal bool,
 	}
 
 	// Handle conflict
-	return s.handleFileConflict(relPath, isLocal)
+	return s.handleFileConflict(relPath, srcMeta, dstMeta, isLocal)
 }
 
 // Copies file from source to destination provider.
@@ -128,30 +159,90 @@ func (s *SyncEngine) syncFileToDestination(src, dst storage.StorageProvider, src
 	direction := getDirection(isLocal)
 	log.Printf("%s sync for %s\n", direction, relPath)
 
-	if err := copyFile(src, dst, relPath, meta.ModTime); err != nil {
+	s.mu.RLock()
+	err := s.checkName(relPath, isLocal, *dstMap)
+	_, exists := (*dstMap)[relPath]
+	s.mu.RUnlock()
+	if err != nil {
+		return s.reportNameConflict(relPath, isLocal, err)
+	}
+
+	if exists {
+		if err := s.archiveVersion(dst, relPath); err != nil {
+			return fmt.Errorf("error archiving %s: %w", relPath, err)
+		}
+	}
+
+	started := time.Now()
+	saved, err := s.copyWithProgress(src, dst, relPath, meta.ModTime, meta.Size, isLocal)
+	if err != nil {
 		return fmt.Errorf("error syncing file %s: %w", relPath, err)
 	}
 
 	// Update state maps
+	s.mu.Lock()
 	(*srcMap)[relPath] = meta
 	(*dstMap)[relPath] = meta
+	s.mu.Unlock()
+
+	// Notify subscribers
+	message := fmt.Sprintf("File synced: %s", relPath)
+	if saved > 0 {
+		message = fmt.Sprintf("File synced: %s (delta transfer saved %d bytes)", relPath, saved)
+	}
+	s.publish(Event{
+		Kind:            EventSync,
+		Path:            relPath,
+		Direction:       direction,
+		Size:            meta.Size,
+		SourceHash:      meta.Hash,
+		DestinationHash: meta.Hash,
+		Duration:        time.Since(started),
+		BytesSaved:      saved,
+		Message:         message,
+	})
+
+	return nil
+}
 
-	// Notify callback
-	if s.eventCallback != nil {
-		s.eventCallback("sync", relPath, direction, fmt.Sprintf("File synced: %s", relPath))
+// Applies changed permissions, ownership or extended attributes to the
+// destination without copying the unchanged content.
+func (s *SyncEngine) syncAttributes(dst storage.StorageProvider, srcMap, dstMap *map[string]models.FileMetadata, relPath string, srcMeta, dstMeta models.FileMetadata, isLocal bool) error {
+	if err := copyAttributes(dst, relPath, srcMeta); err != nil {
+		return err
 	}
 
+	dstMeta.Attrs = srcMeta.Attrs
+	s.mu.Lock()
+	(*srcMap)[relPath] = srcMeta
+	(*dstMap)[relPath] = dstMeta
+	s.mu.Unlock()
+
+	s.publish(Event{
+		Kind:            EventSync,
+		Path:            relPath,
+		Direction:       getDirection(isLocal),
+		SourceHash:      srcMeta.Hash,
+		DestinationHash: dstMeta.Hash,
+		Message:         fmt.Sprintf("Attributes synced: %s", relPath),
+	})
+
 	return nil
 }
 
 // Logs and reports file conflicts.
-func (s *SyncEngine) handleFileConflict(relPath string, isLocal bool) error {
+func (s *SyncEngine) handleFileConflict(relPath string, srcMeta, dstMeta models.FileMetadata, isLocal bool) error {
 	log.Printf("Conflict detected for file %s; destination file is newer\n", relPath)
 
-	if s.eventCallback != nil {
-		direction := getDirection(isLocal)
-		s.eventCallback("conflict", relPath, direction, fmt.Sprintf("File conflict: %s (destination is newer)", relPath))
-	}
+	s.publish(Event{
+		Kind:            EventConflict,
+		Path:            relPath,
+		Direction:       getDirection(isLocal),
+		Size:            srcMeta.Size,
+		SourceHash:      srcMeta.Hash,
+		DestinationHash: dstMeta.Hash,
+		Message:         fmt.Sprintf("File conflict: %s (destination is newer)", relPath),
+	})
 
 	return nil
 }
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"backend/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Creates an engine over two fresh directories without starting it.
+func newTestEngine(t *testing.T) *SyncEngine {
+	t.Helper()
+	local, err := storage.NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := storage.NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSyncEngine(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.watcher.Close() })
+	return s
+}
+
+// Writes a file under a provider's root.
+func writeEngineFile(t *testing.T, provider storage.StorageProvider, relPath string, data []byte) {
+	t.Helper()
+	fullPath := filepath.Join(provider.GetPath(), filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fullPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Fails the test when fn does not return within a few seconds.
+func withinDeadline(t *testing.T, name string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return; the engine is deadlocked", name)
+	}
+}
+
+func TestWriteEventForVanishedFile(t *testing.T) {
+	s := newTestEngine(t)
+	writeEngineFile(t, s.remoteProvider, "saved.txt", []byte("remote copy"))
+	if err := s.buildInitialState(); err != nil {
+		t.Fatal(err)
+	}
+	// The local file was saved through a temporary file and is gone by the
+	// time its write event is handled.
+	s.localMap["saved.txt"] = s.remoteMap["saved.txt"]
+	event := fsnotify.Event{Name: filepath.Join(s.localProvider.GetPath(), "saved.txt"), Op: fsnotify.Write}
+
+	withinDeadline(t, "handleWriteOrChmodEvent", func() {
+		if err := s.handleWriteOrChmodEvent(event, true, "saved.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.localMap["saved.txt"]; ok {
+		t.Error("vanished file still tracked on the local side")
+	}
+	if _, ok := s.remoteMap["saved.txt"]; ok {
+		t.Error("vanished file still tracked on the remote side")
+	}
+	if _, err := s.remoteProvider.Stat("saved.txt"); err == nil {
+		t.Error("deletion was not propagated to the remote side")
+	}
+}
+
+func TestWatcherEventsDuringReconcile(t *testing.T) {
+	s := newTestEngine(t)
+	if err := s.buildInitialState(); err != nil {
+		t.Fatal(err)
+	}
+	s.startWorkers()
+	defer s.Stop()
+
+	const files = 40
+	var reconciled, watched []string
+	for i := range files {
+		relPath := fmt.Sprintf("reconciled/%02d.txt", i)
+		writeEngineFile(t, s.localProvider, relPath, randomBytes(uint64(i), 2000))
+		reconciled = append(reconciled, relPath)
+	}
+	localList, err := s.localProvider.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteList, err := s.remoteProvider.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := s.planActions(localList, remoteList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range files {
+			relPath := fmt.Sprintf("watched/%02d.txt", i)
+			writeEngineFile(t, s.remoteProvider, relPath, randomBytes(uint64(100+i), 2000))
+			watched = append(watched, relPath)
+			event := fsnotify.Event{Name: filepath.Join(s.remoteProvider.GetPath(), filepath.FromSlash(relPath)), Op: fsnotify.Create}
+			s.processEventWithLock(queuedEvent{raw: event, relPath: relPath})
+		}
+	}()
+	if err := s.dispatchActions(context.Background(), actions, nil); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, relPath := range append(reconciled, watched...) {
+		if _, ok := s.localMap[relPath]; !ok {
+			t.Errorf("%s not tracked on the local side", relPath)
+		}
+		if _, ok := s.remoteMap[relPath]; !ok {
+			t.Errorf("%s not tracked on the remote side", relPath)
+		}
+	}
+	for _, relPath := range reconciled {
+		if _, err := s.remoteProvider.Stat(relPath); err != nil {
+			t.Errorf("%s not copied to the remote side: %v", relPath, err)
+		}
+	}
+	for _, relPath := range watched {
+		if _, err := s.localProvider.Stat(relPath); err != nil {
+			t.Errorf("%s not copied to the local side: %v", relPath, err)
+		}
+	}
+}
+
+func TestBackgroundWorkRefusedAfterStop(t *testing.T) {
+	s := newTestEngine(t)
+	s.Stop()
+	if _, err := s.StartSyncJob(); !errors.Is(err, ErrEngineStopped) {
+		t.Fatalf("StartSyncJob after Stop returned %v", err)
+	}
+	s.syncJobsMu.Lock()
+	if len(s.syncJobs) != 0 {
+		t.Errorf("refused job still tracked: %d jobs", len(s.syncJobs))
+	}
+	s.syncJobsMu.Unlock()
+	withinDeadline(t, "replayPaused", func() { s.replayPaused(nil, true) })
+}
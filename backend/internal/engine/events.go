@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of events the engine publishes.
+type EventKind string
+
+const (
+	EventSync         EventKind = "sync"
+	EventDelete       EventKind = "delete"
+	EventMove         EventKind = "move"
+	EventConflict     EventKind = "conflict"
+	EventNameConflict EventKind = "name_conflict"
+	EventRestore      EventKind = "restore"
+	EventProgress     EventKind = "progress"
+	EventJob          EventKind = "job"
+	EventPaused       EventKind = "paused"
+	EventResumed      EventKind = "resumed"
+)
+
+// Describes something that happened to a sync pair. Path holds the job ID for
+// job events and is empty for events about the whole pair. Size, hashes and
+// duration are set where the kind of event has them: the size and hashes of a
+// synced file, how long its copy took and the bytes a delta transfer reused,
+// the bytes copied so far by a transfer or job along with a transfer's total
+// and throughput, and the time a job has been running. Durations are encoded
+// in nanoseconds.
+type Event struct {
+	Sequence        uint64        `json:"sequence"`
+	Kind            EventKind     `json:"type"`
+	Pair            string        `json:"pair"`
+	Path            string        `json:"filePath"`
+	OldPath         string        `json:"oldPath,omitempty"`
+	Direction       string        `json:"direction"`
+	Size            int64         `json:"size,omitempty"`
+	SourceHash      string        `json:"sourceHash,omitempty"`
+	DestinationHash string        `json:"destinationHash,omitempty"`
+	Duration        time.Duration `json:"duration,omitempty"`
+	BytesSaved      int64         `json:"bytesSaved,omitempty"`
+	TotalBytes      int64         `json:"totalBytes,omitempty"`
+	BytesPerSecond  float64       `json:"bytesPerSecond,omitempty"`
+	Error           string        `json:"error,omitempty"`
+	Message         string        `json:"message"`
+	Timestamp       time.Time     `json:"timestamp"`
+}
+
+// Delivers events to any number of subscribers. Every subscriber has its own
+// bounded buffer; publishing never blocks, and events that do not fit a full
+// buffer are dropped for that subscriber and counted.
+type EventBus struct {
+	mu          sync.Mutex
+	sequence    uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// Receives events from an EventBus until closed.
+type Subscription struct {
+	name      string
+	bus       *EventBus
+	events    chan Event
+	delivered atomic.Int64
+	dropped   atomic.Int64
+	closeOnce sync.Once
+}
+
+// Reports how a subscriber keeps up with the events published to it.
+type SubscriberStats struct {
+	Name      string `json:"name"`
+	Buffered  int    `json:"buffered"`
+	Capacity  int    `json:"capacity"`
+	Delivered int64  `json:"delivered"`
+	Dropped   int64  `json:"dropped"`
+}
+
+// Creates an EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Adds a subscriber whose buffer holds up to buffer events.
+func (b *EventBus) Subscribe(name string, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &Subscription{name: name, bus: b, events: make(chan Event, buffer)}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Numbers and timestamps an event and hands it to every subscriber with room
+// in its buffer.
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sequence++
+	event.Sequence = b.sequence
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+			sub.delivered.Add(1)
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Returns the statistics of every subscriber, sorted by name.
+func (b *EventBus) Stats() []SubscriberStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make([]SubscriberStats, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		stats = append(stats, sub.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// Returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Returns the statistics of the subscriber.
+func (s *Subscription) Stats() SubscriberStats {
+	return SubscriberStats{
+		Name:      s.name,
+		Buffered:  len(s.events),
+		Capacity:  cap(s.events),
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+	}
+}
+
+// Removes the subscriber from the bus and closes its channel.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscribers, s)
+		s.bus.mu.Unlock()
+		close(s.events)
+	})
+}
+
+// Publishes an event about the engine's pair.
+func (s *SyncEngine) publish(event Event) {
+	event.Pair = s.pairName
+	s.events.Publish(event)
+}
+
+// Subscribes to the events the engine publishes.
+func (s *SyncEngine) Subscribe(name string, buffer int) *Subscription {
+	return s.events.Subscribe(name, buffer)
+}
+
+// Returns how each event subscriber keeps up, including dropped events.
+func (s *SyncEngine) EventStats() []SubscriberStats {
+	return s.events.Stats()
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestEventBusCountsDropsPerSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	slow := bus.Subscribe("slow", 2)
+	fast := bus.Subscribe("fast", 10)
+	defer slow.Close()
+	defer fast.Close()
+
+	for range 5 {
+		bus.Publish(Event{Kind: EventSync})
+	}
+
+	want := []SubscriberStats{
+		{Name: "fast", Buffered: 5, Capacity: 10, Delivered: 5, Dropped: 0},
+		{Name: "slow", Buffered: 2, Capacity: 2, Delivered: 2, Dropped: 3},
+	}
+	stats := bus.Stats()
+	if len(stats) != len(want) {
+		t.Fatalf("stats for %d subscribers, want %d", len(stats), len(want))
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("got %+v, want %+v", stats[i], want[i])
+		}
+	}
+
+	// The slow subscriber kept the oldest events and gets new ones once it
+	// has drained its buffer.
+	for _, wantSeq := range []uint64{1, 2} {
+		if event := <-slow.Events(); event.Sequence != wantSeq {
+			t.Errorf("slow subscriber got sequence %d, want %d", event.Sequence, wantSeq)
+		}
+	}
+	bus.Publish(Event{Kind: EventSync})
+	if event := <-slow.Events(); event.Sequence != 6 {
+		t.Errorf("slow subscriber got sequence %d after draining, want 6", event.Sequence)
+	}
+	if got := slow.Stats(); got.Delivered != 3 || got.Dropped != 3 {
+		t.Errorf("slow subscriber: %+v", got)
+	}
+}
+
+func TestEventBusSequenceIncreases(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe("all", 400)
+	defer sub.Close()
+
+	// Publishers race, but every subscriber sees one increasing sequence.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				bus.Publish(Event{Kind: EventProgress})
+			}
+		}()
+	}
+	wg.Wait()
+
+	var last uint64
+	for range 400 {
+		event := <-sub.Events()
+		if event.Sequence != last+1 {
+			t.Fatalf("sequence %d followed %d", event.Sequence, last)
+		}
+		if event.Timestamp.IsZero() {
+			t.Errorf("event %d has no timestamp", event.Sequence)
+		}
+		last = event.Sequence
+	}
+}
+
+func TestSubscriptionCloseStopsDelivery(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe("closed", 0)
+	sub.Close()
+	sub.Close()
+
+	bus.Publish(Event{Kind: EventSync})
+	if _, ok := <-sub.Events(); ok {
+		t.Error("a closed subscription received an event")
+	}
+	if stats := bus.Stats(); len(stats) != 0 {
+		t.Errorf("closed subscriber still listed: %+v", stats)
+	}
+}
@@ -1,27 +1,115 @@
 package engine
 
 import (
+	"backend/internal/config"
+	"backend/internal/models"
 	"backend/internal/storage"
 	"fmt"
 	"io"
+	"log"
 	"time"
 )
 
 // Copies a file from src to dst storage providers.
-func copyFile(src storage.StorageProvider, dst storage.StorageProvider, relativePath string, modTime time.Time) error {
+// When the destination already holds a version of the file and supports
+// random access, only the differences are transferred; the returned count is
+// the number of bytes reused from the existing destination file. Progress is
+// reported to the transfer, which may be nil. Links that the source reports
+// as links are recreated as links rather than copied, and the source's
+// permissions, ownership and extended attributes are applied to the copy.
+func copyFile(src storage.StorageProvider, dst storage.StorageProvider, relativePath string, modTime time.Time, progress *transfer) (int64, error) {
+	meta, err := src.Stat(relativePath)
+	if err == nil && meta.IsSymlink() {
+		return 0, copyLink(dst, relativePath, meta.LinkTarget)
+	}
+	saved, ok, err := deltaCopyFile(src, dst, relativePath, modTime, meta.Attrs, progress)
+	if !ok {
+		if err != nil {
+			log.Printf("Delta transfer unavailable for %s, copying whole file: %v\n", relativePath, err)
+		}
+		saved, err = 0, fullCopyFile(src, dst, relativePath, modTime, meta.Attrs, progress)
+	}
+	return saved, err
+}
+
+// Opens a writer for the destination file. Attributes, when given and
+// storable by the destination, are handed to the writer so they are in place
+// before the file is published; writers that cannot take them have them
+// applied after Close instead.
+func openDestination(dst storage.StorageProvider, relativePath string, modTime time.Time, attrs *models.FileAttributes) (io.WriteCloser, error) {
+	writer, err := dst.GetWriter(relativePath, modTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open destination %s: %w", relativePath, err)
+	}
+	if _, ok := dst.(storage.AttributeSetter); !ok || attrs == nil || storage.SetWriterAttributes(writer, *attrs) {
+		return writer, nil
+	}
+	return &attributesOnClose{WriteCloser: writer, dst: dst, relativePath: relativePath, attrs: *attrs}, nil
+}
+
+// Applies attributes to the destination once its writer has closed, for
+// writers that cannot apply them themselves.
+type attributesOnClose struct {
+	io.WriteCloser
+	dst          storage.StorageProvider
+	relativePath string
+	attrs        models.FileAttributes
+}
+
+// Discards the content written so far.
+func (w *attributesOnClose) Abort() error {
+	return storage.AbortWriter(w.WriteCloser)
+}
+
+// Closes the writer, then applies the attributes.
+func (w *attributesOnClose) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return copyAttributes(w.dst, w.relativePath, models.FileMetadata{Attrs: &w.attrs})
+}
+
+// Applies the permissions, ownership and extended attributes of a source file
+// to the destination when the source records them and the destination can
+// store them.
+func copyAttributes(dst storage.StorageProvider, relativePath string, src models.FileMetadata) error {
+	setter, ok := dst.(storage.AttributeSetter)
+	if !ok || src.Attrs == nil {
+		return nil
+	}
+	if err := setter.SetAttributes(relativePath, *src.Attrs); err != nil {
+		return fmt.Errorf("failed to apply attributes to %s: %w", relativePath, err)
+	}
+	return nil
+}
+
+// Recreates a link on the destination, which must be able to store links.
+func copyLink(dst storage.StorageProvider, relativePath, target string) error {
+	linker, ok := dst.(storage.LinkProvider)
+	if !ok {
+		return fmt.Errorf("%w: destination of %s cannot store links", storage.ErrSymlinkSkipped, relativePath)
+	}
+	if err := linker.CreateLink(relativePath, target); err != nil {
+		return fmt.Errorf("failed to copy link %s: %w", relativePath, err)
+	}
+	return nil
+}
+
+// Copies the whole content of a file from src to dst.
+func fullCopyFile(src storage.StorageProvider, dst storage.StorageProvider, relativePath string, modTime time.Time, attrs *models.FileAttributes, progress *transfer) error {
 	reader, err := src.GetReader(relativePath)
 	if err != nil {
 		return fmt.Errorf("failed to open source %s: %w", relativePath, err)
 	}
 	defer reader.Close()
 
-	writer, err := dst.GetWriter(relativePath, modTime)
+	writer, err := openDestination(dst, relativePath, modTime, attrs)
 	if err != nil {
-		return fmt.Errorf("failed to open destination %s: %w", relativePath, err)
+		return err
 	}
 
-	if _, err := io.Copy(writer, reader); err != nil {
-		writer.Close()
+	if _, err := io.Copy(writer, progress.wrap(reader)); err != nil {
+		storage.AbortWriter(writer)
 		return fmt.Errorf("failed to copy %s: %w", relativePath, err)
 	}
 
@@ -31,3 +119,53 @@ func copyFile(src storage.StorageProvider, dst storage.StorageProvider, relative
 
 	return nil
 }
+
+// Rewrites the destination file from block signatures of its current content
+// and a delta generated from the source. The boolean is false when the delta
+// path does not apply and the caller should fall back to a full copy.
+func deltaCopyFile(src storage.StorageProvider, dst storage.StorageProvider, relativePath string, modTime time.Time, attrs *models.FileAttributes, progress *transfer) (int64, bool, error) {
+	randomAccess, ok := dst.(storage.RandomAccessProvider)
+	if !ok {
+		return 0, false, nil
+	}
+	dstMeta, err := dst.Stat(relativePath)
+	if err != nil || dstMeta.Size < config.DeltaMinFileSize {
+		return 0, false, nil
+	}
+	srcMeta, err := src.Stat(relativePath)
+	if err != nil || srcMeta.Size < config.DeltaMinFileSize {
+		return 0, false, nil
+	}
+
+	basis, err := randomAccess.GetReaderAt(relativePath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer basis.Close()
+	sig, err := computeSignature(basis, dstMeta.Size)
+	if err != nil {
+		return 0, false, err
+	}
+
+	reader, err := src.GetReader(relativePath)
+	if err != nil {
+		return 0, true, fmt.Errorf("failed to open source %s: %w", relativePath, err)
+	}
+	defer reader.Close()
+
+	writer, err := openDestination(dst, relativePath, modTime, attrs)
+	if err != nil {
+		return 0, true, err
+	}
+
+	applier := &deltaApplier{basis: basis, sig: sig, out: writer}
+	if err := generateDelta(progress.wrap(reader), sig, applier.apply); err != nil {
+		storage.AbortWriter(writer)
+		return 0, true, fmt.Errorf("failed to apply delta for %s: %w", relativePath, err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, true, fmt.Errorf("failed to finalize destination %s: %w", relativePath, err)
+	}
+
+	return applier.saved, true, nil
+}
@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Phases and statuses of a sync job.
+const (
+	PhaseQueued       = "queued"
+	PhaseScanning     = "scanning"
+	PhaseComparing    = "comparing"
+	PhaseTransferring = "transferring"
+	PhaseDone         = "done"
+
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Returned when a requested sync job does not exist.
+var ErrJobNotFound = errors.New("sync job not found")
+
+// Reports the progress of a background sync.
+type SyncJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Phase      string     `json:"phase"`
+	FilesDone  int        `json:"filesDone"`
+	FilesTotal int        `json:"filesTotal"`
+	BytesDone  int64      `json:"bytesDone"`
+	BytesTotal int64      `json:"bytesTotal"`
+	ETASeconds *float64   `json:"etaSeconds,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Tracks a running sync job and its cancellation. The ID and start time are
+// set once at creation and may be read without holding mu.
+type syncJob struct {
+	id        string
+	startedAt time.Time
+
+	mu            sync.Mutex
+	info          SyncJob
+	cancel        context.CancelFunc
+	transferStart time.Time
+	lastEvent     time.Time
+}
+
+// Starts a manual sync in the background and returns its job.
+func (s *SyncEngine) StartSyncJob() (SyncJob, error) {
+	if s.IsGuardTripped() {
+		return SyncJob{}, ErrGuardTripped
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job := &syncJob{
+		id:        strconv.FormatUint(s.lastSyncJob.Add(1), 10),
+		startedAt: now,
+		cancel:    cancel,
+	}
+	job.info = SyncJob{
+		ID:        job.id,
+		Status:    JobRunning,
+		Phase:     PhaseQueued,
+		StartedAt: job.startedAt,
+	}
+
+	s.syncJobsMu.Lock()
+	s.syncJobs[job.id] = job
+	s.pruneSyncJobsLocked()
+	s.syncJobsMu.Unlock()
+
+	err := s.goBackground(func() {
+		defer cancel()
+		// Stopping the engine cancels the job between files.
+		go func() {
+			select {
+			case <-s.stopCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		s.syncMu.Lock()
+		log.Printf("Starting manual sync job %s...\n", job.id)
+		err := s.runSyncJob(ctx, false, job)
+		s.syncMu.Unlock()
+		s.finishJob(job, err)
+	})
+	if err != nil {
+		cancel()
+		s.syncJobsMu.Lock()
+		delete(s.syncJobs, job.id)
+		s.syncJobsMu.Unlock()
+		return SyncJob{}, err
+	}
+	return job.snapshot(), nil
+}
+
+// Returns a sync job by ID.
+func (s *SyncEngine) GetSyncJob(id string) (SyncJob, error) {
+	s.syncJobsMu.Lock()
+	job, ok := s.syncJobs[id]
+	s.syncJobsMu.Unlock()
+	if !ok {
+		return SyncJob{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job.snapshot(), nil
+}
+
+// Cancels a running sync job. The file being transferred is finished first.
+func (s *SyncEngine) CancelSyncJob(id string) error {
+	s.syncJobsMu.Lock()
+	job, ok := s.syncJobs[id]
+	s.syncJobsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	job.cancel()
+	return nil
+}
+
+// Records the outcome of a job and notifies clients.
+func (s *SyncEngine) finishJob(job *syncJob, err error) {
+	job.mu.Lock()
+	now := time.Now()
+	job.info.FinishedAt = &now
+	job.info.Phase = PhaseDone
+	job.info.ETASeconds = nil
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.info.Status = JobCancelled
+	case err != nil:
+		job.info.Status = JobFailed
+		job.info.Error = err.Error()
+	default:
+		job.info.Status = JobCompleted
+	}
+	status := job.info.Status
+	job.mu.Unlock()
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Sync job %s failed: %v\n", job.id, err)
+	} else {
+		log.Printf("Sync job %s %s\n", job.id, status)
+	}
+	s.emitJobProgress(job, true)
+}
+
+// Drops the oldest finished jobs beyond the configured limit.
+// Callers must hold s.syncJobsMu.
+func (s *SyncEngine) pruneSyncJobsLocked() {
+	for len(s.syncJobs) > config.MaxStoredJobs {
+		var oldest *syncJob
+		for _, job := range s.syncJobs {
+			if job.snapshot().Status == JobRunning {
+				continue
+			}
+			if oldest == nil || job.startedAt.Before(oldest.startedAt) {
+				oldest = job
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(s.syncJobs, oldest.id)
+	}
+}
+
+// Moves a job to a new phase.
+func (s *SyncEngine) setJobPhase(job *syncJob, phase string) {
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	job.info.Phase = phase
+	if phase == PhaseTransferring {
+		job.transferStart = time.Now()
+	}
+	job.mu.Unlock()
+	s.emitJobProgress(job, true)
+}
+
+// Sets the amount of work a job has to transfer.
+func (s *SyncEngine) setJobTotals(job *syncJob, actions []SyncAction) {
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	job.info.FilesTotal = len(actions)
+	for _, action := range actions {
+		job.info.BytesTotal += action.Size
+	}
+	job.mu.Unlock()
+}
+
+// Counts a transferred file and updates the ETA.
+func (s *SyncEngine) advanceJob(job *syncJob, bytes int64) {
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	job.info.FilesDone++
+	job.info.BytesDone += bytes
+	elapsed := time.Since(job.transferStart).Seconds()
+	if job.info.BytesDone > 0 && elapsed > 0 {
+		rate := float64(job.info.BytesDone) / elapsed
+		eta := float64(job.info.BytesTotal-job.info.BytesDone) / rate
+		job.info.ETASeconds = &eta
+	}
+	job.mu.Unlock()
+	s.emitJobProgress(job, false)
+}
+
+// Streams job progress to clients, at most once per interval unless forced.
+func (s *SyncEngine) emitJobProgress(job *syncJob, force bool) {
+	job.mu.Lock()
+	if !force && time.Since(job.lastEvent) < config.ProgressEventInterval {
+		job.mu.Unlock()
+		return
+	}
+	job.lastEvent = time.Now()
+	info := job.info
+	job.mu.Unlock()
+
+	s.publish(Event{
+		Kind:      EventJob,
+		Path:      info.ID,
+		Direction: "both",
+		Size:      info.BytesDone,
+		Duration:  time.Since(info.StartedAt),
+		Error:     info.Error,
+		Message: fmt.Sprintf("Sync job %s %s (%s): %d/%d files, %d/%d bytes",
+			info.ID, info.Status, info.Phase, info.FilesDone, info.FilesTotal, info.BytesDone, info.BytesTotal),
+	})
+}
+
+// Returns a copy of the job's public state.
+func (j *syncJob) snapshot() SyncJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
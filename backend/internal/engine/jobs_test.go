@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSyncJobsGetDistinctIDs(t *testing.T) {
+	s := newTestEngine(t)
+	defer s.Stop()
+
+	// No more than are kept, so none is pruned.
+	jobs := config.MaxStoredJobs
+	ids := make(chan string, jobs)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job, err := s.StartSyncJob()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- job.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("job ID %s handed out twice", id)
+		}
+		seen[id] = true
+		if _, err := s.GetSyncJob(id); err != nil {
+			t.Errorf("job %s: %v", id, err)
+		}
+	}
+	if len(seen) != jobs {
+		t.Errorf("%d distinct IDs for %d jobs", len(seen), jobs)
+	}
+}
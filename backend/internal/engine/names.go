@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"backend/internal/models"
+	"backend/internal/storage"
+	"log"
+)
+
+// Describes a path left out of a sync because the destination cannot store
+// it under its own name.
+type NameConflict struct {
+	RelativePath string `json:"relativePath"`
+	Direction    string `json:"direction"`
+	Message      string `json:"message"`
+	err          error
+}
+
+// Sets the naming rules of both roots, which decide what names can be synced
+// to them. Must be called before Run.
+func (s *SyncEngine) SetNameProfiles(local, remote storage.NameProfile) {
+	s.localNames = local
+	s.remoteNames = remote
+}
+
+// Returns the naming rules of the destination of a sync.
+func (s *SyncEngine) destinationNames(isLocal bool) storage.NameProfile {
+	if isLocal {
+		return s.remoteNames
+	}
+	return s.localNames
+}
+
+// Checks that a path can be written to the destination under its own name:
+// that it breaks none of the destination's naming rules, unless those names
+// are escaped, and that it does not fold together with a different path
+// already in dstMap.
+func (s *SyncEngine) checkName(relPath string, isLocal bool, dstMap map[string]models.FileMetadata) error {
+	profile := s.destinationNames(isLocal)
+	if !profile.Escape {
+		if err := profile.Check(relPath); err != nil {
+			return err
+		}
+	}
+	if !profile.Folds() || !missingPrefix(relPath, dstMap) {
+		return nil
+	}
+	return newNameIndex(profile, dstMap).check(relPath)
+}
+
+// Reports a path the destination cannot store and returns the error to fail
+// its sync with.
+func (s *SyncEngine) reportNameConflict(relPath string, isLocal bool, err error) error {
+	log.Printf("Not syncing %s: %v\n", relPath, err)
+	s.publish(Event{
+		Kind:      EventNameConflict,
+		Path:      relPath,
+		Direction: getDirection(isLocal),
+		Error:     err.Error(),
+		Message:   err.Error(),
+	})
+	return err
+}
+
+// Drops the copies and directories of a plan that their destination cannot
+// store under their own names, returning the remaining actions and what was
+// dropped. Overwrites and attribute updates target paths the destination
+// already holds and are kept.
+func (s *SyncEngine) filterNames(actions []SyncAction, localMap, remoteMap map[string]models.FileMetadata) ([]SyncAction, []NameConflict) {
+	indexes := make(map[bool]nameIndex)
+	kept := actions[:0]
+	var conflicts []NameConflict
+	for _, action := range actions {
+		if action.Kind != ActionCopy && action.Kind != ActionCreateDir {
+			kept = append(kept, action)
+			continue
+		}
+		isLocal := action.Direction == getDirection(true)
+		profile := s.destinationNames(isLocal)
+		var err error
+		if !profile.Escape {
+			err = profile.Check(action.RelativePath)
+		}
+		if err == nil && profile.Folds() {
+			index, ok := indexes[isLocal]
+			if !ok {
+				dstMap := remoteMap
+				if !isLocal {
+					dstMap = localMap
+				}
+				index = newNameIndex(profile, dstMap)
+				indexes[isLocal] = index
+			}
+			// Planned paths claim their names, so two new paths that fold
+			// together are caught as well.
+			if err = index.check(action.RelativePath); err == nil {
+				index.add(action.RelativePath)
+			}
+		}
+		if err != nil {
+			conflicts = append(conflicts, NameConflict{
+				RelativePath: action.RelativePath,
+				Direction:    action.Direction,
+				Message:      err.Error(),
+				err:          err,
+			})
+			continue
+		}
+		kept = append(kept, action)
+	}
+	return kept, conflicts
+}
+
+// Indexes the paths of a destination by the form under which the destination
+// tells them apart. Paths already there are trusted even when several of them
+// share a key.
+type nameIndex struct {
+	profile storage.NameProfile
+	paths   map[string]string
+	exact   map[string]bool
+}
+
+// Indexes every path of a state map.
+func newNameIndex(profile storage.NameProfile, stateMap map[string]models.FileMetadata) nameIndex {
+	index := nameIndex{
+		profile: profile,
+		paths:   make(map[string]string, len(stateMap)),
+		exact:   make(map[string]bool, len(stateMap)),
+	}
+	for relPath := range stateMap {
+		index.paths[profile.Key(relPath)] = relPath
+		index.exact[relPath] = true
+	}
+	return index
+}
+
+// Records a path and its parent directories.
+func (idx nameIndex) add(relPath string) {
+	for _, prefix := range pathPrefixes(relPath) {
+		key := idx.profile.Key(prefix)
+		if _, taken := idx.paths[key]; !taken {
+			idx.paths[key] = prefix
+		}
+		idx.exact[prefix] = true
+	}
+}
+
+// Returns an error when the path or one of its parent directories folds
+// together with a different path in the index.
+func (idx nameIndex) check(relPath string) error {
+	for _, prefix := range pathPrefixes(relPath) {
+		if idx.exact[prefix] {
+			continue
+		}
+		if other, taken := idx.paths[idx.profile.Key(prefix)]; taken {
+			return &storage.NameError{Path: relPath, Profile: idx.profile.Name, Conflict: other}
+		}
+	}
+	return nil
+}
+
+// Reports whether the path or one of its parent directories is missing from
+// the state map, so its name has not been checked against the destination yet.
+func missingPrefix(relPath string, stateMap map[string]models.FileMetadata) bool {
+	for _, prefix := range pathPrefixes(relPath) {
+		if _, exists := stateMap[prefix]; !exists {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the path and each of its parent directories, shortest first.
+func pathPrefixes(relPath string) []string {
+	var prefixes []string
+	for i, r := range relPath {
+		if r == '/' {
+			prefixes = append(prefixes, relPath[:i])
+		}
+	}
+	return append(prefixes, relPath)
+}
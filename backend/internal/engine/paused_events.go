@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"log"
+)
+
+// Records an event that arrives while sync is paused so it can be replayed
+// on resume. Only the latest event per path is kept; past the configured
+// bound the individual events are dropped in favour of a full reconcile.
+// Reports false when sync is not paused and the event should run now.
+func (s *SyncEngine) deferWhilePaused(event queuedEvent) bool {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if !s.isPaused {
+		return false
+	}
+	if s.pausedOverflow {
+		return true
+	}
+	key := s.eventKey(event.isLocal, event.relPath)
+	if _, exists := s.pausedEvents[key]; !exists && len(s.pausedEvents) >= config.MaxPausedEvents {
+		log.Printf("More than %d paths changed while paused; a full sync will run on resume\n", config.MaxPausedEvents)
+		s.pausedEvents = make(map[string]queuedEvent)
+		s.pausedOverflow = true
+		return true
+	}
+	s.pausedEvents[key] = event
+	return true
+}
+
+// Returns how many changed paths are waiting for sync to resume, and whether
+// so many changed that a full reconcile will run instead.
+func (s *SyncEngine) PendingChanges() (int, bool) {
+	s.pauseMu.RLock()
+	defer s.pauseMu.RUnlock()
+	return len(s.pausedEvents), s.pausedOverflow
+}
+
+// Unpauses sync and takes the changes recorded while paused.
+// Callers must hold s.pauseMu.
+func (s *SyncEngine) unpauseLocked() ([]queuedEvent, bool) {
+	s.isPaused = false
+	events := make([]queuedEvent, 0, len(s.pausedEvents))
+	for _, event := range s.pausedEvents {
+		events = append(events, event)
+	}
+	overflow := s.pausedOverflow
+	s.pausedEvents = make(map[string]queuedEvent)
+	s.pausedOverflow = false
+	return events, overflow
+}
+
+// Discards the changes recorded while paused.
+// Callers must hold s.pauseMu.
+func (s *SyncEngine) discardPausedLocked() {
+	s.pausedEvents = make(map[string]queuedEvent)
+	s.pausedOverflow = false
+}
+
+// Processes the changes recorded while paused in the background, either by
+// handing them to the workers or, after an overflow, with a full reconcile.
+func (s *SyncEngine) replayPaused(events []queuedEvent, overflow bool) {
+	if !overflow && len(events) == 0 {
+		return
+	}
+	select {
+	case <-s.stopCh:
+		return
+	default:
+	}
+
+	if !overflow {
+		// Replayed changes bypass the queue bound so none are dropped.
+		log.Printf("Replaying %d changes recorded while paused\n", len(events))
+		s.jobs.pushAll(events)
+		return
+	}
+
+	err := s.goBackground(func() {
+		if err := s.ManualSync(); err != nil {
+			log.Printf("error running full sync after resume: %v\n", err)
+		}
+	})
+	if err != nil {
+		log.Println("Engine stopping; changes recorded while paused are left for the next startup reconciliation")
+	}
+}
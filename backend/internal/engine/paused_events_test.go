@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"fmt"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Returns a queued event for a path on one side.
+func pausedEvent(isLocal bool, relPath string, op fsnotify.Op) queuedEvent {
+	return queuedEvent{
+		raw:     fsnotify.Event{Name: relPath, Op: op},
+		isLocal: isLocal,
+		relPath: relPath,
+	}
+}
+
+// Returns write events for count distinct local paths.
+func distinctEvents(count int) []queuedEvent {
+	events := make([]queuedEvent, count)
+	for i := range events {
+		events[i] = pausedEvent(true, fmt.Sprintf("f%d", i), fsnotify.Write)
+	}
+	return events
+}
+
+func TestDeferWhilePaused(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []queuedEvent
+		pending  int
+		overflow bool
+	}{
+		{"none", nil, 0, false},
+		{"coalesced per path", []queuedEvent{
+			pausedEvent(true, "a", fsnotify.Create),
+			pausedEvent(true, "a", fsnotify.Write),
+			pausedEvent(true, "a", fsnotify.Chmod),
+			pausedEvent(false, "a", fsnotify.Write),
+			pausedEvent(true, "b", fsnotify.Write),
+		}, 3, false},
+		{"at the bound", distinctEvents(config.MaxPausedEvents), config.MaxPausedEvents, false},
+		{"known path at the bound", append(distinctEvents(config.MaxPausedEvents),
+			pausedEvent(true, "f0", fsnotify.Remove)), config.MaxPausedEvents, false},
+		{"past the bound", distinctEvents(config.MaxPausedEvents + 1), 0, true},
+		{"after overflow", append(distinctEvents(config.MaxPausedEvents+1),
+			pausedEvent(true, "late", fsnotify.Write)), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestEngine(t)
+			s.Pause()
+			for _, event := range tt.events {
+				if !s.deferWhilePaused(event) {
+					t.Fatalf("event for %s was not deferred while paused", event.relPath)
+				}
+			}
+			if pending, overflow := s.PendingChanges(); pending != tt.pending || overflow != tt.overflow {
+				t.Errorf("got %d pending, overflow %v; want %d, %v", pending, overflow, tt.pending, tt.overflow)
+			}
+
+			s.pauseMu.Lock()
+			events, overflow := s.unpauseLocked()
+			s.pauseMu.Unlock()
+			if len(events) != tt.pending || overflow != tt.overflow {
+				t.Errorf("resume took %d events, overflow %v", len(events), overflow)
+			}
+			if pending, overflow := s.PendingChanges(); pending != 0 || overflow {
+				t.Errorf("%d changes, overflow %v left after resume", pending, overflow)
+			}
+			if s.deferWhilePaused(pausedEvent(true, "a", fsnotify.Write)) {
+				t.Error("an event was deferred after resume")
+			}
+		})
+	}
+}
+
+func TestDeferWhilePausedKeepsLatestEvent(t *testing.T) {
+	s := newTestEngine(t)
+	s.Pause()
+	s.deferWhilePaused(pausedEvent(true, "a", fsnotify.Create))
+	s.deferWhilePaused(pausedEvent(true, "a", fsnotify.Remove))
+
+	s.pauseMu.Lock()
+	events, _ := s.unpauseLocked()
+	s.pauseMu.Unlock()
+	if len(events) != 1 || events[0].raw.Op != fsnotify.Remove {
+		t.Errorf("replayed %v, want only the removal", events)
+	}
+}
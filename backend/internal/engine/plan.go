@@ -0,0 +1,386 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/storage"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of actions in a sync plan.
+const (
+	ActionCopy       = "copy"
+	ActionOverwrite  = "overwrite"
+	ActionConflict   = "conflict"
+	ActionCreateDir  = "mkdir"
+	ActionAttributes = "attributes"
+)
+
+// Describes a single change reconciliation would make.
+// Conflicts are files that differ on both sides with equal mod times; like
+// overwrites they are resolved by copying in the given direction. Directories
+// missing on one side are created there, so empty ones are kept too, and
+// files whose content matches but whose permissions or attributes differ
+// take those of the side changed last.
+type SyncAction struct {
+	Kind          string    `json:"kind"`
+	RelativePath  string    `json:"relativePath"`
+	Direction     string    `json:"direction"`
+	Size          int64     `json:"size"`
+	SourceModTime time.Time `json:"sourceModTime"`
+	source        models.FileMetadata
+	destination   models.FileMetadata
+}
+
+// Totals of a sync plan.
+type PlanSummary struct {
+	CopiesToRemote      int   `json:"copiesToRemote"`
+	CopiesToLocal       int   `json:"copiesToLocal"`
+	Overwrites          int   `json:"overwrites"`
+	Conflicts           int   `json:"conflicts"`
+	DirectoriesToCreate int   `json:"directoriesToCreate"`
+	AttributeUpdates    int   `json:"attributeUpdates"`
+	NameConflicts       int   `json:"nameConflicts"`
+	BytesToTransfer     int64 `json:"bytesToTransfer"`
+}
+
+// Lists what a reconciliation would do without touching either side, and
+// the paths it would leave out because their destination cannot store them.
+type SyncPlan struct {
+	ID            string         `json:"id"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	Actions       []SyncAction   `json:"actions"`
+	NameConflicts []NameConflict `json:"nameConflicts"`
+	Summary       PlanSummary    `json:"summary"`
+}
+
+// Reports the outcome of applying a stored plan.
+type PlanResult struct {
+	Applied []string `json:"applied"`
+	Skipped []string `json:"skipped"`
+}
+
+// Returned when a requested plan does not exist or has already been applied.
+var ErrPlanNotFound = errors.New("sync plan not found")
+
+// Computes what a manual sync would do without modifying either provider.
+// The plan is kept so exactly those actions can later be applied by ID.
+func (s *SyncEngine) PlanSync() (*SyncPlan, error) {
+	localMap, err := s.localProvider.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local files: %w", err)
+	}
+	remoteMap, err := s.remoteProvider.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote files: %w", err)
+	}
+	dropHidden(localMap)
+	dropHidden(remoteMap)
+
+	s.mu.RLock()
+	carryHashes(localMap, s.localMap)
+	carryHashes(remoteMap, s.remoteMap)
+	s.mu.RUnlock()
+
+	actions, err := s.planActions(localMap, remoteMap)
+	if err != nil {
+		return nil, err
+	}
+	actions, conflicts := s.filterNames(actions, localMap, remoteMap)
+	plan := &SyncPlan{
+		ID:            strconv.FormatUint(s.lastPlan.Add(1), 10),
+		CreatedAt:     time.Now(),
+		Actions:       actions,
+		NameConflicts: conflicts,
+	}
+	plan.Summary.NameConflicts = len(conflicts)
+	for _, action := range actions {
+		switch {
+		case action.Kind == ActionOverwrite:
+			plan.Summary.Overwrites++
+		case action.Kind == ActionConflict:
+			plan.Summary.Conflicts++
+		case action.Kind == ActionCreateDir:
+			plan.Summary.DirectoriesToCreate++
+		case action.Kind == ActionAttributes:
+			plan.Summary.AttributeUpdates++
+		case action.Direction == getDirection(true):
+			plan.Summary.CopiesToRemote++
+		default:
+			plan.Summary.CopiesToLocal++
+		}
+		plan.Summary.BytesToTransfer += action.Size
+	}
+
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
+	s.plans[plan.ID] = plan
+	if len(s.plans) > config.MaxStoredPlans {
+		var oldest *SyncPlan
+		for _, stored := range s.plans {
+			if oldest == nil || stored.CreatedAt.Before(oldest.CreatedAt) {
+				oldest = stored
+			}
+		}
+		delete(s.plans, oldest.ID)
+	}
+	return plan, nil
+}
+
+// Applies the actions of a stored plan. Actions whose source or destination
+// changed since the plan was computed are skipped rather than re-planned.
+func (s *SyncEngine) ApplyPlan(id string) (*PlanResult, error) {
+	if s.IsGuardTripped() {
+		return nil, ErrGuardTripped
+	}
+	s.planMu.Lock()
+	plan, ok := s.plans[id]
+	delete(s.plans, id)
+	s.planMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPlanNotFound, id)
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	result := &PlanResult{Applied: []string{}, Skipped: []string{}}
+	for _, action := range plan.Actions {
+		applied, err := s.applyCurrentAction(action)
+		if err != nil {
+			return result, err
+		}
+		if !applied {
+			result.Skipped = append(result.Skipped, action.RelativePath)
+			continue
+		}
+		result.Applied = append(result.Applied, action.RelativePath)
+	}
+	log.Printf("Sync plan %s applied: %d actions, %d skipped\n", id, len(result.Applied), len(result.Skipped))
+	return result, nil
+}
+
+// Works out the actions needed to reconcile two state maps. Files present on
+// both sides are compared by a bounded pool of hashing goroutines; hashes
+// computed along the way are stored back into the maps. A path that is a
+// directory on one side and a file on the other is left alone.
+func (s *SyncEngine) planActions(localMap, remoteMap map[string]models.FileMetadata) ([]SyncAction, error) {
+	var actions []SyncAction
+	var shared []comparison
+	for relPath, localMeta := range localMap {
+		remoteMeta, existsInRemote := remoteMap[relPath]
+		switch {
+		case !existsInRemote:
+			actions = append(actions, newSyncAction(copyKind(localMeta), true, localMeta, models.FileMetadata{}))
+		case localMeta.IsDir() != remoteMeta.IsDir():
+			log.Printf("Skipping %s: %s on local but %s on remote\n", relPath, entryKind(localMeta), entryKind(remoteMeta))
+		case !localMeta.IsDir():
+			shared = append(shared, comparison{local: localMeta, remote: remoteMeta})
+		}
+	}
+	for relPath, remoteMeta := range remoteMap {
+		if _, existsInLocal := localMap[relPath]; !existsInLocal {
+			actions = append(actions, newSyncAction(copyKind(remoteMeta), false, remoteMeta, models.FileMetadata{}))
+		}
+	}
+
+	err := parallelEach(len(shared), config.ScanHashWorkers, func(i int) error {
+		c := &shared[i]
+		s.throttleScan(hashCost(c.local, c.remote))
+		same, err := contentMatches(s.localProvider, s.remoteProvider, &c.local, &c.remote)
+		if err != nil {
+			return fmt.Errorf("error comparing file %s: %w", c.local.RelativePath, err)
+		}
+		c.same = same
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range shared {
+		localMap[c.local.RelativePath] = c.local
+		remoteMap[c.remote.RelativePath] = c.remote
+		switch {
+		case c.same && !c.local.Attrs.Matches(c.remote.Attrs):
+			if c.remote.Attrs.ChangeTime.After(c.local.Attrs.ChangeTime) {
+				actions = append(actions, newAttributesAction(false, c.remote, c.local))
+			} else {
+				actions = append(actions, newAttributesAction(true, c.local, c.remote))
+			}
+		case c.same:
+		case c.local.ModTime.After(c.remote.ModTime):
+			actions = append(actions, newSyncAction(ActionOverwrite, true, c.local, c.remote))
+		case c.local.ModTime.Equal(c.remote.ModTime):
+			actions = append(actions, newSyncAction(ActionConflict, false, c.remote, c.local))
+		default:
+			actions = append(actions, newSyncAction(ActionOverwrite, false, c.remote, c.local))
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].RelativePath < actions[j].RelativePath
+	})
+	return actions, nil
+}
+
+// Holds a file present on both sides and the outcome of comparing it.
+type comparison struct {
+	local, remote models.FileMetadata
+	same          bool
+}
+
+// Returns how many bytes comparing two entries will hash.
+func hashCost(localMeta, remoteMeta models.FileMetadata) int64 {
+	if _, known := localMeta.Matches(remoteMeta); known {
+		return 0
+	}
+	var cost int64
+	if !localMeta.HasHash() {
+		cost += localMeta.Size
+	}
+	if !remoteMeta.HasHash() {
+		cost += remoteMeta.Size
+	}
+	return cost
+}
+
+// Returns the kind of action that brings an entry missing on one side over.
+func copyKind(meta models.FileMetadata) string {
+	if meta.IsDir() {
+		return ActionCreateDir
+	}
+	return ActionCopy
+}
+
+// Creates an action copying source over destination in the given direction.
+func newSyncAction(kind string, isLocal bool, source, destination models.FileMetadata) SyncAction {
+	return SyncAction{
+		Kind:          kind,
+		RelativePath:  source.RelativePath,
+		Direction:     getDirection(isLocal),
+		Size:          source.Size,
+		SourceModTime: source.ModTime,
+		source:        source,
+		destination:   destination,
+	}
+}
+
+// Creates an action applying the attributes of source to destination, which
+// has the same content and so transfers nothing.
+func newAttributesAction(isLocal bool, source, destination models.FileMetadata) SyncAction {
+	action := newSyncAction(ActionAttributes, isLocal, source, destination)
+	action.Size = 0
+	return action
+}
+
+// Applies an action under its file's lock unless either side changed since
+// it was planned. Reports whether the action was applied.
+func (s *SyncEngine) applyCurrentAction(action SyncAction) (bool, error) {
+	lock := s.lockFor(action.RelativePath)
+	lock.Lock()
+	defer lock.Unlock()
+	if !s.actionCurrent(action) {
+		log.Printf("Skipping planned %s of %s; file changed since planning\n", action.Kind, action.RelativePath)
+		return false, nil
+	}
+	return true, s.applyAction(action)
+}
+
+// Reports whether both sides of an action are still as they were planned.
+// Callers must hold the file's lock.
+func (s *SyncEngine) actionCurrent(action SyncAction) bool {
+	srcProvider, dstProvider := s.getProviders(action.Direction == getDirection(true))
+	if !unchanged(srcProvider, action.source) {
+		return false
+	}
+	if action.Kind == ActionCreateDir {
+		// Copies of files inside the directory may already have created it.
+		meta, err := dstProvider.Stat(action.RelativePath)
+		return err != nil || meta.IsDir()
+	}
+	if action.Kind == ActionCopy {
+		_, err := dstProvider.Stat(action.RelativePath)
+		return err != nil
+	}
+	return unchanged(dstProvider, action.destination)
+}
+
+// Reports whether a file still has the size and mod time it was planned with,
+// or a directory still exists.
+func unchanged(provider storage.StorageProvider, planned models.FileMetadata) bool {
+	meta, err := provider.Stat(planned.RelativePath)
+	if err != nil || meta.IsDir() != planned.IsDir() {
+		return false
+	}
+	return planned.IsDir() || meta.Size == planned.Size && meta.ModTime.Equal(planned.ModTime)
+}
+
+// Performs a single planned action and updates the state maps. The copy runs
+// without s.mu so other files and API reads are not blocked meanwhile.
+// Callers must hold the file's lock.
+func (s *SyncEngine) applyAction(action SyncAction) error {
+	isLocal := action.Direction == getDirection(true)
+	srcProvider, dstProvider := s.getProviders(isLocal)
+	srcMap, dstMap := s.getStateMaps(isLocal)
+	relPath := action.RelativePath
+	dstSide := sideName(!isLocal)
+
+	if action.Kind == ActionCreateDir {
+		log.Printf("Directory %s exists on %s but not on %s. Creating it...\n", relPath, sideName(isLocal), dstSide)
+		if err := dstProvider.EnsureDir(relPath); err != nil {
+			return fmt.Errorf("error creating directory %s on %s: %w", relPath, dstSide, err)
+		}
+		s.mu.Lock()
+		(*srcMap)[relPath] = action.source
+		(*dstMap)[relPath] = action.source
+		s.mu.Unlock()
+		return nil
+	}
+
+	if action.Kind == ActionAttributes {
+		log.Printf("File %s has different attributes on %s. Updating %s...\n", relPath, sideName(isLocal), dstSide)
+		if err := copyAttributes(dstProvider, relPath, action.source); err != nil {
+			return fmt.Errorf("error updating attributes of %s on %s: %w", relPath, dstSide, err)
+		}
+		destination := action.destination
+		destination.Attrs = action.source.Attrs
+		s.mu.Lock()
+		(*srcMap)[relPath] = action.source
+		(*dstMap)[relPath] = destination
+		s.mu.Unlock()
+		return nil
+	}
+
+	if action.Kind == ActionCopy {
+		log.Printf("File %s exists on %s but not on %s. Copying to %s...\n", relPath, sideName(isLocal), dstSide, dstSide)
+		if _, err := s.copyWithProgress(srcProvider, dstProvider, relPath, action.source.ModTime, action.Size, isLocal); err != nil {
+			return fmt.Errorf("error copying file %s to %s: %w", relPath, dstSide, err)
+		}
+		s.mu.Lock()
+		(*srcMap)[relPath] = action.source
+		(*dstMap)[relPath] = action.source
+		s.mu.Unlock()
+		log.Printf("File %s copied to %s successfully.\n", relPath, dstSide)
+		return nil
+	}
+
+	log.Printf("File %s is newer on %s. Updating %s file...\n", relPath, sideName(isLocal), dstSide)
+	if err := s.archiveVersion(dstProvider, relPath); err != nil {
+		return fmt.Errorf("error archiving file %s on %s: %w", relPath, dstSide, err)
+	}
+	saved, err := s.copyWithProgress(srcProvider, dstProvider, relPath, action.source.ModTime, action.Size, isLocal)
+	if err != nil {
+		return fmt.Errorf("error updating file %s to %s: %w", relPath, dstSide, err)
+	}
+	s.mu.Lock()
+	(*srcMap)[relPath] = action.source
+	(*dstMap)[relPath] = action.source
+	s.mu.Unlock()
+	log.Printf("File %s updated successfully (%d bytes reused).\n", relPath, saved)
+	return nil
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPlansGetDistinctIDs(t *testing.T) {
+	s := newTestEngine(t)
+	writeEngineFile(t, s.localProvider, "a.txt", []byte("a"))
+
+	// No more than are kept, so none is pruned.
+	plans := config.MaxStoredPlans
+	ids := make(chan string, plans)
+	var wg sync.WaitGroup
+	for range plans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plan, err := s.PlanSync()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- plan.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("plan ID %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
+	if len(s.plans) != len(seen) {
+		t.Errorf("%d plans stored for %d IDs", len(s.plans), len(seen))
+	}
+}
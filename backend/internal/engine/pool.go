@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Bounds for the adaptive worker pool.
+type PoolConfig struct {
+	MinWorkers int `json:"minWorkers"`
+	MaxWorkers int `json:"maxWorkers"`
+}
+
+// Records one resize of the worker pool and why it happened.
+type PoolDecision struct {
+	At     time.Time `json:"at"`
+	From   int       `json:"from"`
+	To     int       `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// Reports the size of the worker pool, the load it measured over the last
+// adjustment interval and its recent decisions.
+type PoolStatus struct {
+	PoolConfig
+	Workers         int            `json:"workers"`
+	Busy            int            `json:"busy"`
+	QueueDepth      int            `json:"queueDepth"`
+	EventsPerSecond float64        `json:"eventsPerSecond"`
+	BytesPerSecond  float64        `json:"bytesPerSecond"`
+	ErrorRate       float64        `json:"errorRate"`
+	Decisions       []PoolDecision `json:"decisions"`
+}
+
+// Holds the controller state of the worker pool.
+type workerPool struct {
+	config    PoolConfig
+	workers   int
+	started   bool
+	last      poolSample
+	grew      bool
+	holdUntil time.Time
+	decisions []PoolDecision
+}
+
+// Is the load measured over one adjustment interval.
+type poolSample struct {
+	events    float64
+	bytes     float64
+	errorRate float64
+}
+
+// Returns the pool bounds used unless configured otherwise.
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MinWorkers: config.DefaultMinWorkers,
+		MaxWorkers: config.DefaultMaxWorkers,
+	}
+}
+
+// Validates and installs pool bounds, resizing the pool into them.
+func (s *SyncEngine) SetPoolConfig(pool PoolConfig) error {
+	if pool.MinWorkers < 1 {
+		return fmt.Errorf("minimum workers must be at least 1")
+	}
+	if pool.MaxWorkers < pool.MinWorkers {
+		return fmt.Errorf("maximum workers must not be below the minimum")
+	}
+
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	s.pool.config = pool
+	switch {
+	case s.pool.workers < pool.MinWorkers:
+		s.resizePoolLocked(pool.MinWorkers, "raised to configured minimum")
+	case s.pool.workers > pool.MaxWorkers:
+		s.resizePoolLocked(pool.MaxWorkers, "lowered to configured maximum")
+	}
+	return nil
+}
+
+// Returns the pool size, its measured load and its recent decisions.
+func (s *SyncEngine) GetPoolStatus() PoolStatus {
+	depth, busy := s.jobs.load()
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	return PoolStatus{
+		PoolConfig:      s.pool.config,
+		Workers:         s.pool.workers,
+		Busy:            busy,
+		QueueDepth:      depth,
+		EventsPerSecond: s.pool.last.events,
+		BytesPerSecond:  s.pool.last.bytes,
+		ErrorRate:       s.pool.last.errorRate,
+		Decisions:       append([]PoolDecision{}, s.pool.decisions...),
+	}
+}
+
+// Starts the initial workers.
+func (s *SyncEngine) startWorkers() {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	s.pool.started = true
+	s.spawnWorkersLocked(s.pool.workers)
+}
+
+// Starts n worker goroutines.
+// Callers must hold s.poolMu.
+func (s *SyncEngine) spawnWorkersLocked(n int) {
+	for i := 0; i < n; i++ {
+		s.workerWG.Add(1)
+		go s.worker()
+	}
+}
+
+// Grows or shrinks the pool to size workers and records the decision.
+// Shrinking retires idle workers as they next look for work. Before the
+// engine runs only the size to start with changes.
+// Callers must hold s.poolMu.
+func (s *SyncEngine) resizePoolLocked(size int, reason string) {
+	from := s.pool.workers
+	if size == from {
+		return
+	}
+	select {
+	case <-s.stopCh:
+		return
+	default:
+	}
+	switch {
+	case !s.pool.started:
+	case size > from:
+		s.spawnWorkersLocked(size - from)
+	default:
+		s.jobs.retire(from - size)
+	}
+	s.pool.workers = size
+	s.jobs.setMaxLarge(largeLaneSlots(size))
+
+	s.pool.decisions = append(s.pool.decisions, PoolDecision{At: time.Now(), From: from, To: size, Reason: reason})
+	if len(s.pool.decisions) > config.MaxPoolDecisions {
+		s.pool.decisions = s.pool.decisions[len(s.pool.decisions)-config.MaxPoolDecisions:]
+	}
+	log.Printf("Worker pool resized from %d to %d: %s\n", from, size, reason)
+}
+
+// Resizes the pool every adjustment interval until the engine stops.
+func (s *SyncEngine) runPoolController() {
+	defer s.backgroundWG.Done()
+	ticker := time.NewTicker(config.PoolAdjustInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.adjustPool(now.Sub(last))
+			last = now
+		}
+	}
+}
+
+// Measures the load of the last interval and resizes the pool by one worker:
+// down while errors are frequent or workers sit idle, back down when the
+// previous growth did not raise throughput (a saturated disk or provider),
+// and up while events wait with every worker busy.
+func (s *SyncEngine) adjustPool(elapsed time.Duration) {
+	events := s.processedEvents.Swap(0)
+	failed := s.failedEvents.Swap(0)
+	bytes := s.processedBytes.Swap(0)
+	depth, busy := s.jobs.load()
+
+	sample := poolSample{
+		events: float64(events) / elapsed.Seconds(),
+		bytes:  float64(bytes) / elapsed.Seconds(),
+	}
+	if events > 0 {
+		sample.errorRate = float64(failed) / float64(events)
+	}
+
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	previous, grew := s.pool.last, s.pool.grew
+	s.pool.last, s.pool.grew = sample, false
+	workers, bounds := s.pool.workers, s.pool.config
+
+	switch {
+	case sample.errorRate > config.PoolMaxErrorRate && workers > bounds.MinWorkers:
+		s.resizePoolLocked(workers-1, fmt.Sprintf("error rate %.0f%%", sample.errorRate*100))
+	case grew && !sample.improvesOn(previous) && workers > bounds.MinWorkers:
+		s.pool.holdUntil = time.Now().Add(config.PoolGrowthCooldown)
+		s.resizePoolLocked(workers-1, "throughput did not improve after growing")
+	case depth > 0 && busy >= workers && workers < bounds.MaxWorkers && time.Now().After(s.pool.holdUntil):
+		s.pool.grew = true
+		s.resizePoolLocked(workers+1, fmt.Sprintf("%d events queued with all workers busy", depth))
+	case depth == 0 && busy < workers/2 && workers > bounds.MinWorkers:
+		s.resizePoolLocked(workers-1, "workers idle")
+	}
+}
+
+// Reports whether a sample processed meaningfully more events or bytes than
+// the one before it.
+func (sample poolSample) improvesOn(previous poolSample) bool {
+	gain := 1 + config.PoolMinThroughputGain
+	return sample.events > previous.events*gain || sample.bytes > previous.bytes*gain
+}
+
+// Returns how many workers may serve the large lane at once.
+func largeLaneSlots(workers int) int {
+	return max(1, workers/config.LargeLaneWorkerDivisor)
+}
@@ -0,0 +1,387 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Lanes of the job queue, served in this order. Large transfers have their
+// own lane with a cap on concurrent workers so they cannot starve the rest.
+const (
+	LaneHigh   = "high"
+	LaneNormal = "normal"
+	LaneLarge  = "large"
+	LaneLow    = "low"
+)
+
+// Lanes in the order workers take from them.
+var laneOrder = []string{LaneHigh, LaneNormal, LaneLarge, LaneLow}
+
+// Assigns paths matching a glob to a lane. "**" matches across directories,
+// "*" and "?" within one path component.
+type PriorityRule struct {
+	Pattern  string `json:"pattern"`
+	Priority string `json:"priority"`
+
+	match *regexp.Regexp
+}
+
+// Describes one lane of the job queue.
+type LaneStatus struct {
+	Name              string   `json:"name"`
+	Pending           int      `json:"pending"`
+	PendingBytes      int64    `json:"pendingBytes"`
+	Active            int      `json:"active"`
+	OldestWaitSeconds float64  `json:"oldestWaitSeconds"`
+	Next              []string `json:"next"`
+}
+
+// Describes the composition of the job queue.
+type QueueStatus struct {
+	Lanes       []LaneStatus   `json:"lanes"`
+	MaxLarge    int            `json:"maxLargeActive"`
+	Rules       []PriorityRule `json:"rules"`
+	Capacity    int            `json:"capacity"`
+	TotalQueued int            `json:"totalQueued"`
+}
+
+// Holds queued events in priority lanes and hands them to workers.
+type jobQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    map[string][]queuedEvent
+	queued   map[queueKey]string
+	inFlight map[string]bool
+	active   map[string]int
+	size     int
+	capacity int
+	maxLarge int
+	retiring int
+	closed   bool
+}
+
+// Identifies the path an event refers to. Reconcile copies are keyed apart
+// from watcher events so neither replaces the other.
+type queueKey struct {
+	isLocal bool
+	relPath string
+	planned bool
+}
+
+// Creates an empty queue bounded to capacity events.
+func newJobQueue(capacity, maxLarge int) *jobQueue {
+	q := &jobQueue{
+		lanes:    make(map[string][]queuedEvent),
+		queued:   make(map[queueKey]string),
+		inFlight: make(map[string]bool),
+		active:   make(map[string]int),
+		capacity: capacity,
+		maxLarge: maxLarge,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Adds an event to its lane, replacing any event still queued for the same
+// path. Reports false when the queue is full or closed.
+func (q *jobQueue) push(event queuedEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if _, ok := q.queued[event.key()]; !ok && q.size >= q.capacity {
+		return false
+	}
+	q.appendLocked(event)
+	return true
+}
+
+// Adds events regardless of capacity, for replays that must not be dropped.
+func (q *jobQueue) pushAll(events []queuedEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	for _, event := range events {
+		q.appendLocked(event)
+	}
+}
+
+// Appends an event to its lane and wakes a worker. A queued event for the
+// same path is dropped so a file written in many steps is synced once.
+// Callers must hold q.mu.
+func (q *jobQueue) appendLocked(event queuedEvent) {
+	key := event.key()
+	if lane, ok := q.queued[key]; ok {
+		events := q.lanes[lane]
+		for i := range events {
+			if events[i].key() == key {
+				q.lanes[lane] = append(events[:i], events[i+1:]...)
+				q.size--
+				break
+			}
+		}
+	}
+	event.enqueuedAt = time.Now()
+	q.lanes[event.lane] = append(q.lanes[event.lane], event)
+	q.queued[key] = event.lane
+	q.size++
+	q.cond.Signal()
+}
+
+// Waits for the next event to process. Reports false once the queue is closed
+// or when the calling worker is retired, telling it to exit.
+// Callers must call done with the event when they have processed it.
+func (q *jobQueue) pop() (queuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return queuedEvent{}, false
+		}
+		if q.retiring > 0 {
+			q.retiring--
+			return queuedEvent{}, false
+		}
+		if lane, i, ok := q.nextLocked(); ok {
+			events := q.lanes[lane]
+			event := events[i]
+			q.lanes[lane] = append(events[:i:i], events[i+1:]...)
+			delete(q.queued, event.key())
+			q.inFlight[event.relPath] = true
+			q.size--
+			q.active[lane]++
+			return event, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// Picks the event to serve next: the oldest low-priority event once it has
+// aged past the threshold, then lanes in priority order, skipping the large
+// lane while it is at its concurrency cap. Paths still being processed are
+// skipped so workers do not wait on each other's file locks.
+// Callers must hold q.mu.
+func (q *jobQueue) nextLocked() (string, int, bool) {
+	if low := q.lanes[LaneLow]; len(low) > 0 && time.Since(low[0].enqueuedAt) > config.QueueAgingThreshold {
+		if i, ok := q.firstReadyLocked(LaneLow); ok {
+			return LaneLow, i, true
+		}
+	}
+	for _, lane := range laneOrder {
+		if lane == LaneLarge && q.active[LaneLarge] >= q.maxLarge {
+			continue
+		}
+		if i, ok := q.firstReadyLocked(lane); ok {
+			return lane, i, true
+		}
+	}
+	return "", 0, false
+}
+
+// Returns the index of the first event in a lane whose path is not in flight
+// on either side, matching the per-path locks workers take.
+// Callers must hold q.mu.
+func (q *jobQueue) firstReadyLocked(lane string) (int, bool) {
+	for i, event := range q.lanes[lane] {
+		if !q.inFlight[event.relPath] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Marks an event returned by pop as processed and wakes workers that may
+// have been waiting for its path or for a large-lane slot.
+func (q *jobQueue) done(event queuedEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active[event.lane]--
+	delete(q.inFlight, event.relPath)
+	q.cond.Broadcast()
+}
+
+// Makes the next n calls to pop report false so that many workers exit.
+func (q *jobQueue) retire(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retiring += n
+	q.cond.Broadcast()
+}
+
+// Changes how many workers may serve the large lane at once.
+func (q *jobQueue) setMaxLarge(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.maxLarge = n
+	q.cond.Broadcast()
+}
+
+// Returns the number of queued events and of events being processed.
+func (q *jobQueue) load() (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	busy := 0
+	for _, n := range q.active {
+		busy += n
+	}
+	return q.size, busy
+}
+
+// Wakes all workers and makes pop report false.
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Summarizes every lane, listing the next few paths of each.
+func (q *jobQueue) status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := QueueStatus{MaxLarge: q.maxLarge, Capacity: q.capacity, TotalQueued: q.size}
+	for _, lane := range laneOrder {
+		events := q.lanes[lane]
+		laneStatus := LaneStatus{Name: lane, Pending: len(events), Active: q.active[lane], Next: []string{}}
+		for i, event := range events {
+			laneStatus.PendingBytes += event.size
+			if i < config.QueueStatusPreview {
+				laneStatus.Next = append(laneStatus.Next, event.relPath)
+			}
+		}
+		if len(events) > 0 {
+			laneStatus.OldestWaitSeconds = time.Since(events[0].enqueuedAt).Seconds()
+		}
+		status.Lanes = append(status.Lanes, laneStatus)
+	}
+	return status
+}
+
+// Returns the key of the path an event refers to.
+func (event queuedEvent) key() queueKey {
+	return queueKey{isLocal: event.isLocal, relPath: event.relPath, planned: event.action != nil}
+}
+
+// Sets the lane of an event: metadata operations and directories go first,
+// and files are placed by size and priority rules.
+func (s *SyncEngine) classify(event *queuedEvent) {
+	if event.raw.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		event.lane = LaneHigh
+		return
+	}
+	info, err := os.Stat(event.raw.Name)
+	if err != nil || info.IsDir() {
+		event.lane = LaneHigh
+		return
+	}
+	event.size = info.Size()
+	event.lane = s.laneFor(event.relPath, event.size)
+}
+
+// Returns the lane for a file transfer: large files get their own lane, the
+// first matching priority rule decides for the rest, and small files
+// otherwise go first.
+func (s *SyncEngine) laneFor(relPath string, size int64) string {
+	if size >= config.LargeFileThreshold {
+		return LaneLarge
+	}
+	s.priorityMu.RLock()
+	rules := s.priorityRules
+	s.priorityMu.RUnlock()
+	for _, rule := range rules {
+		if rule.match.MatchString(relPath) {
+			return rule.Priority
+		}
+	}
+	if size <= config.SmallFileThreshold {
+		return LaneHigh
+	}
+	return LaneNormal
+}
+
+// Validates and installs priority rules; the first matching rule wins.
+func (s *SyncEngine) SetPriorityRules(rules []PriorityRule) error {
+	compiled := make([]PriorityRule, len(rules))
+	for i, rule := range rules {
+		switch rule.Priority {
+		case LaneHigh, LaneNormal, LaneLow:
+		default:
+			return fmt.Errorf("invalid priority %q for %s: expected high, normal or low", rule.Priority, rule.Pattern)
+		}
+		match, err := globToRegexp(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+		rule.match = match
+		compiled[i] = rule
+	}
+	s.priorityMu.Lock()
+	s.priorityRules = compiled
+	s.priorityMu.Unlock()
+	return nil
+}
+
+// Returns the composition of the job queue and the priority rules.
+func (s *SyncEngine) GetQueueStatus() QueueStatus {
+	status := s.jobs.status()
+	s.priorityMu.RLock()
+	status.Rules = append([]PriorityRule{}, s.priorityRules...)
+	s.priorityMu.RUnlock()
+	return status
+}
+
+// Parses rules written as "pattern=priority" separated by commas.
+func ParsePriorityRules(spec string) ([]PriorityRule, error) {
+	var rules []PriorityRule
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		pattern, priority, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid priority rule %q: expected pattern=priority", entry)
+		}
+		rules = append(rules, PriorityRule{Pattern: strings.TrimSpace(pattern), Priority: strings.TrimSpace(priority)})
+	}
+	return rules, nil
+}
+
+// Compiles a glob over slash-separated relative paths into a regexp.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	glob := []rune(pattern)
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// "**/" also matches no directories at all.
+					i++
+					expr.WriteString("(?:.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"testing"
+	"time"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.iso", "disk.iso", true},
+		{"*.iso", "images/disk.iso", false},
+		{"*.iso", "disk.iso.part", false},
+		{"**/*.iso", "disk.iso", true},
+		{"**/*.iso", "images/old/disk.iso", true},
+		{"docs/**", "docs/a.md", true},
+		{"docs/**", "docs/guide/a.md", true},
+		{"docs/**", "docs", false},
+		{"docs/**", "other/docs/a.md", false},
+		{"**/build/*", "build/out.o", true},
+		{"**/build/*", "src/build/out.o", true},
+		{"**/build/*", "src/build/sub/out.o", false},
+		{"src/*/main.go", "src/app/main.go", true},
+		{"src/*/main.go", "src/app/cmd/main.go", false},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file12.txt", false},
+		{"file?.txt", "file/.txt", false},
+		{"r?sum?.txt", "résumé.txt", true},
+		{"naïve*", "naïve.md", true},
+		{"a+b (1).txt", "a+b (1).txt", true},
+		{"a+b (1).txt", "aab (1).txt", false},
+		{"[abc].txt", "[abc].txt", true},
+		{"[abc].txt", "a.txt", false},
+		{"**", "any/path/at/all", true},
+	}
+	for _, tt := range tests {
+		match, err := globToRegexp(tt.pattern)
+		if err != nil {
+			t.Fatalf("globToRegexp(%q): %v", tt.pattern, err)
+		}
+		if got := match.MatchString(tt.path); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParsePriorityRules(t *testing.T) {
+	rules, err := ParsePriorityRules(" docs/**=high, *.iso = low ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0] != (PriorityRule{Pattern: "docs/**", Priority: "high"}) || rules[1] != (PriorityRule{Pattern: "*.iso", Priority: "low"}) {
+		t.Fatalf("parsed %+v", rules)
+	}
+	if _, err := ParsePriorityRules("docs/**"); err == nil {
+		t.Error("rule without priority was accepted")
+	}
+	s := &SyncEngine{}
+	if err := s.SetPriorityRules([]PriorityRule{{Pattern: "*.iso", Priority: LaneLarge}}); err == nil {
+		t.Error("rule for the large lane was accepted")
+	}
+}
+
+func TestLaneFor(t *testing.T) {
+	s := &SyncEngine{}
+	if err := s.SetPriorityRules([]PriorityRule{
+		{Pattern: "docs/**", Priority: LaneHigh},
+		{Pattern: "**/*.iso", Priority: LaneLow},
+		{Pattern: "**", Priority: LaneNormal},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		size int64
+		want string
+	}{
+		{"docs/big.pdf", 10 << 20, LaneHigh},
+		{"images/disk.iso", 100, LaneLow},
+		{"images/disk.iso", config.LargeFileThreshold, LaneLarge},
+		{"notes.txt", 10, LaneNormal},
+	}
+	for _, tt := range tests {
+		if got := s.laneFor(tt.path, tt.size); got != tt.want {
+			t.Errorf("laneFor(%q, %d) = %s, want %s", tt.path, tt.size, got, tt.want)
+		}
+	}
+
+	s = &SyncEngine{}
+	if got := s.laneFor("small.txt", config.SmallFileThreshold); got != LaneHigh {
+		t.Errorf("small file went to %s", got)
+	}
+	if got := s.laneFor("medium.bin", config.SmallFileThreshold+1); got != LaneNormal {
+		t.Errorf("medium file went to %s", got)
+	}
+}
+
+// Pops an event and fails the test if none is ready right away.
+func popNow(t *testing.T, q *jobQueue) queuedEvent {
+	t.Helper()
+	q.mu.Lock()
+	_, _, ok := q.nextLocked()
+	q.mu.Unlock()
+	if !ok {
+		t.Fatal("no event ready")
+	}
+	event, _ := q.pop()
+	return event
+}
+
+func TestJobQueueServesLanesInOrder(t *testing.T) {
+	q := newJobQueue(100, 1)
+	for _, event := range []queuedEvent{
+		{relPath: "low1", lane: LaneLow},
+		{relPath: "large1", lane: LaneLarge},
+		{relPath: "normal1", lane: LaneNormal},
+		{relPath: "high1", lane: LaneHigh},
+		{relPath: "large2", lane: LaneLarge},
+		{relPath: "normal2", lane: LaneNormal},
+		{relPath: "high2", lane: LaneHigh},
+	} {
+		if !q.push(event) {
+			t.Fatalf("push of %s failed", event.relPath)
+		}
+	}
+
+	var order []string
+	for range 5 {
+		event := popNow(t, q)
+		order = append(order, event.relPath)
+		if event.lane != LaneLarge {
+			q.done(event)
+		}
+	}
+	// large1 is still in flight, so the second large event waits for it
+	// and the low lane is served instead.
+	want := []string{"high1", "high2", "normal1", "normal2", "large1"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("served %v, want %v", order, want)
+		}
+	}
+	if event := popNow(t, q); event.relPath != "low1" {
+		t.Fatalf("served %s while the large lane was at its cap", event.relPath)
+	}
+	q.mu.Lock()
+	_, _, ready := q.nextLocked()
+	q.mu.Unlock()
+	if ready {
+		t.Fatal("large lane exceeded its cap")
+	}
+
+	q.setMaxLarge(2)
+	if event := popNow(t, q); event.relPath != "large2" {
+		t.Fatalf("served %s after raising the cap", event.relPath)
+	}
+}
+
+func TestJobQueueMergesAndSkipsPathsInFlight(t *testing.T) {
+	q := newJobQueue(2, 1)
+	q.push(queuedEvent{relPath: "a", lane: LaneNormal, size: 1})
+	q.push(queuedEvent{relPath: "a", lane: LaneHigh, size: 2})
+	if size, _ := q.load(); size != 1 {
+		t.Fatalf("queue holds %d events after a repeated path", size)
+	}
+	q.push(queuedEvent{relPath: "b", lane: LaneNormal})
+	if q.push(queuedEvent{relPath: "c", lane: LaneNormal}) {
+		t.Fatal("push beyond capacity succeeded")
+	}
+
+	first := popNow(t, q)
+	if first.relPath != "a" || first.size != 2 {
+		t.Fatalf("served %+v, want the latest event for a", first)
+	}
+	// A new event for a path in flight waits until the path is done.
+	q.push(queuedEvent{relPath: "a", lane: LaneHigh})
+	if event := popNow(t, q); event.relPath != "b" {
+		t.Fatalf("served %s while a was in flight", event.relPath)
+	}
+	q.done(first)
+	if event := popNow(t, q); event.relPath != "a" {
+		t.Fatalf("served %s after a was done", event.relPath)
+	}
+}
+
+func TestJobQueueAgesLowPriorityEvents(t *testing.T) {
+	q := newJobQueue(100, 1)
+	q.push(queuedEvent{relPath: "low", lane: LaneLow})
+	q.push(queuedEvent{relPath: "high", lane: LaneHigh})
+	if event := popNow(t, q); event.relPath != "high" {
+		t.Fatalf("served %s before the low event aged", event.relPath)
+	}
+
+	q.push(queuedEvent{relPath: "high2", lane: LaneHigh})
+	q.mu.Lock()
+	q.lanes[LaneLow][0].enqueuedAt = time.Now().Add(-config.QueueAgingThreshold - time.Second)
+	q.mu.Unlock()
+	if event := popNow(t, q); event.relPath != "low" {
+		t.Fatalf("served %s before the aged low event", event.relPath)
+	}
+}
+
+func TestJobQueueRetireAndClose(t *testing.T) {
+	q := newJobQueue(10, 1)
+	q.push(queuedEvent{relPath: "a", lane: LaneHigh})
+	q.retire(1)
+	if _, ok := q.pop(); ok {
+		t.Fatal("retired worker was handed an event")
+	}
+	done := make(chan bool)
+	go func() {
+		_, ok := q.pop()
+		done <- ok
+		_, ok = q.pop()
+		done <- ok
+	}()
+	if !<-done {
+		t.Fatal("pop failed with an event queued")
+	}
+	q.close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("pop succeeded on a closed queue")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not wake a waiting worker")
+	}
+	if q.push(queuedEvent{relPath: "b", lane: LaneHigh}) {
+		t.Fatal("push to a closed queue succeeded")
+	}
+}
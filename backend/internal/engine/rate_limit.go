@@ -0,0 +1,212 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Limits in bytes per second; zero means unlimited. The global limit is
+// shared by every engine in the process, the pair limit by both directions
+// of this engine, and the scan limit throttles hashing during reconciliation.
+type RateLimits struct {
+	GlobalBytesPerSec   int64 `json:"globalBytesPerSec"`
+	PairBytesPerSec     int64 `json:"pairBytesPerSec"`
+	UploadBytesPerSec   int64 `json:"uploadBytesPerSec"`
+	DownloadBytesPerSec int64 `json:"downloadBytesPerSec"`
+	ScanBytesPerSec     int64 `json:"scanBytesPerSec"`
+}
+
+// Replaces the base limits during a daily time window such as "01:00-05:00".
+type RateLimitWindow struct {
+	Window string `json:"window"`
+	RateLimits
+}
+
+// Holds the base limits and time-of-day overrides; the first matching
+// window wins.
+type RateLimitConfig struct {
+	RateLimits
+	Windows []RateLimitWindow `json:"windows,omitempty"`
+}
+
+// Shared by all engines so the global limit spans every pair.
+var globalLimiter = newTokenBucket()
+
+// Per-engine limiters for each kind of traffic.
+type rateLimiters struct {
+	pair     *tokenBucket
+	upload   *tokenBucket
+	download *tokenBucket
+	scan     *tokenBucket
+}
+
+// Creates unlimited limiters.
+func newRateLimiters() rateLimiters {
+	return rateLimiters{
+		pair:     newTokenBucket(),
+		upload:   newTokenBucket(),
+		download: newTokenBucket(),
+		scan:     newTokenBucket(),
+	}
+}
+
+// Validates and installs rate limits, applying them immediately.
+func (s *SyncEngine) SetRateLimits(limits RateLimitConfig) error {
+	windows := make([]*timeWindow, len(limits.Windows))
+	for i, window := range limits.Windows {
+		parsed, err := parseTimeWindow(window.Window)
+		if err != nil {
+			return err
+		}
+		if err := window.validate(); err != nil {
+			return err
+		}
+		windows[i] = parsed
+	}
+	if err := limits.validate(); err != nil {
+		return err
+	}
+
+	s.limitsMu.Lock()
+	s.limitConfig = limits
+	s.limitWindows = windows
+	s.limitsMu.Unlock()
+	s.applyRateLimits(time.Now())
+	return nil
+}
+
+// Returns the configured limits and the ones in effect right now.
+func (s *SyncEngine) GetRateLimits() (RateLimitConfig, RateLimits) {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	return s.limitConfig, s.effectiveLimitsLocked(time.Now())
+}
+
+// Sets the limiter rates from the limits in effect at now.
+func (s *SyncEngine) applyRateLimits(now time.Time) {
+	s.limitsMu.Lock()
+	limits := s.effectiveLimitsLocked(now)
+	s.limitsMu.Unlock()
+
+	globalLimiter.setRate(limits.GlobalBytesPerSec)
+	s.limiters.pair.setRate(limits.PairBytesPerSec)
+	s.limiters.upload.setRate(limits.UploadBytesPerSec)
+	s.limiters.download.setRate(limits.DownloadBytesPerSec)
+	s.limiters.scan.setRate(limits.ScanBytesPerSec)
+}
+
+// Returns the limits of the first window containing now, or the base limits.
+// Callers must hold s.limitsMu.
+func (s *SyncEngine) effectiveLimitsLocked(now time.Time) RateLimits {
+	for i, window := range s.limitWindows {
+		if window.contains(now) {
+			return s.limitConfig.Windows[i].RateLimits
+		}
+	}
+	return s.limitConfig.RateLimits
+}
+
+// Reapplies the limits as time-of-day windows open and close.
+func (s *SyncEngine) runRateLimitScheduler() {
+	defer s.backgroundWG.Done()
+	ticker := time.NewTicker(config.RateLimitRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.applyRateLimits(now)
+		}
+	}
+}
+
+// Returns the limiters a transfer in the given direction must pass.
+func (s *SyncEngine) transferLimiters(isLocal bool) []*tokenBucket {
+	direction := s.limiters.download
+	if isLocal {
+		direction = s.limiters.upload
+	}
+	return []*tokenBucket{globalLimiter, s.limiters.pair, direction}
+}
+
+// Waits until size bytes may be hashed under the scan limit.
+func (s *SyncEngine) throttleScan(size int64) {
+	s.limiters.scan.wait(size, s.stopCh)
+}
+
+// Rejects negative limits.
+func (limits RateLimits) validate() error {
+	for _, limit := range []int64{limits.GlobalBytesPerSec, limits.PairBytesPerSec, limits.UploadBytesPerSec, limits.DownloadBytesPerSec, limits.ScanBytesPerSec} {
+		if limit < 0 {
+			return fmt.Errorf("rate limits must not be negative")
+		}
+	}
+	return nil
+}
+
+// Is a token bucket holding up to one second of traffic.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// Creates an unlimited bucket.
+func newTokenBucket() *tokenBucket {
+	return &tokenBucket{last: time.Now()}
+}
+
+// Changes the rate in bytes per second; zero removes the limit.
+func (b *tokenBucket) setRate(bytesPerSec int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == float64(bytesPerSec) {
+		return
+	}
+	b.refillLocked(time.Now())
+	b.rate = float64(bytesPerSec)
+	b.tokens = min(b.tokens, b.rate)
+	if bytesPerSec > 0 {
+		log.Printf("Rate limit set to %d bytes/s\n", bytesPerSec)
+	}
+}
+
+// Takes n tokens, sleeping until the debt is repaid or done is closed.
+func (b *tokenBucket) wait(n int64, done <-chan struct{}) {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.refillLocked(time.Now())
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+}
+
+// Adds tokens for the time elapsed since the last refill.
+// Callers must hold b.mu.
+func (b *tokenBucket) refillLocked(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	}
+	b.last = now
+}
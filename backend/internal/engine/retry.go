@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/storage"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Describes a path whose sync failed permanently or ran out of retries.
+type FailedPath struct {
+	RelativePath string    `json:"relativePath"`
+	Location     string    `json:"location"`
+	Operation    string    `json:"operation"`
+	Attempts     int       `json:"attempts"`
+	LastError    string    `json:"lastError"`
+	Permanent    bool      `json:"permanent"`
+	FailedAt     time.Time `json:"failedAt"`
+	event        queuedEvent
+}
+
+// Tracks a failed event waiting to be retried.
+type pendingRetry struct {
+	event    queuedEvent
+	attempts int
+	due      time.Time
+}
+
+// Records a failed event and schedules it for another attempt with
+// exponential backoff, or moves it to the dead-letter list when the error is
+// permanent or the retries are exhausted.
+func (s *SyncEngine) scheduleRetry(event queuedEvent, err error) {
+	key := s.eventKey(event.isLocal, event.relPath)
+
+	s.retryMu.Lock()
+	defer s.retryMu.Unlock()
+
+	retry, ok := s.retries[key]
+	if !ok {
+		retry = &pendingRetry{}
+		s.retries[key] = retry
+	}
+	retry.event = event
+	retry.attempts++
+
+	permanent := isPermanentError(err)
+	if permanent || retry.attempts > config.MaxRetryAttempts {
+		delete(s.retries, key)
+		s.deadLetters[key] = FailedPath{
+			RelativePath: event.relPath,
+			Location:     sideName(event.isLocal),
+			Operation:    event.raw.Op.String(),
+			Attempts:     retry.attempts,
+			LastError:    err.Error(),
+			Permanent:    permanent,
+			FailedAt:     time.Now(),
+			event:        event,
+		}
+		log.Printf("Giving up on %s after %d attempts: %v\n", event.relPath, retry.attempts, err)
+		return
+	}
+
+	delay := retryBackoff(retry.attempts)
+	retry.due = time.Now().Add(delay)
+	log.Printf("Retrying %s in %s (attempt %d of %d)\n", event.relPath, delay.Round(time.Millisecond), retry.attempts, config.MaxRetryAttempts)
+}
+
+// Forgets any retry or dead letter for a path once it synced successfully.
+func (s *SyncEngine) clearRetry(event queuedEvent) {
+	key := s.eventKey(event.isLocal, event.relPath)
+	s.retryMu.Lock()
+	defer s.retryMu.Unlock()
+	delete(s.retries, key)
+	delete(s.deadLetters, key)
+}
+
+// Lists paths that failed permanently or exhausted their retries, newest first.
+func (s *SyncEngine) ListFailures() []FailedPath {
+	s.retryMu.Lock()
+	defer s.retryMu.Unlock()
+	failures := make([]FailedPath, 0, len(s.deadLetters))
+	for _, failure := range s.deadLetters {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].FailedAt.After(failures[j].FailedAt)
+	})
+	return failures
+}
+
+// Moves every dead-lettered path back into the retry queue with a fresh
+// attempt budget. Returns how many paths were requeued.
+func (s *SyncEngine) RetryFailures() int {
+	s.retryMu.Lock()
+	defer s.retryMu.Unlock()
+	count := 0
+	for key, failure := range s.deadLetters {
+		s.retries[key] = &pendingRetry{event: failure.event, due: time.Now()}
+		delete(s.deadLetters, key)
+		count++
+	}
+	log.Printf("Requeued %d failed paths\n", count)
+	return count
+}
+
+// Returns how many events are waiting to be retried.
+func (s *SyncEngine) PendingRetryCount() int {
+	s.retryMu.Lock()
+	defer s.retryMu.Unlock()
+	return len(s.retries)
+}
+
+// Periodically hands due retries to the workers until the engine stops.
+func (s *SyncEngine) runRetryScheduler() {
+	defer s.backgroundWG.Done()
+	ticker := time.NewTicker(config.RetryPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.dispatchDueRetries()
+		}
+	}
+}
+
+// Enqueues retries whose backoff has elapsed.
+func (s *SyncEngine) dispatchDueRetries() {
+	now := time.Now()
+	s.retryMu.Lock()
+	var due []queuedEvent
+	for _, retry := range s.retries {
+		if !retry.due.IsZero() && !retry.due.After(now) {
+			// Cleared until the attempt reports back through scheduleRetry.
+			retry.due = time.Time{}
+			due = append(due, retry.event)
+		}
+	}
+	s.retryMu.Unlock()
+
+	for _, event := range due {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		if s.jobs.push(event) {
+			continue
+		}
+		log.Printf("Job queue full, postponing retry of %s\n", event.relPath)
+		s.retryMu.Lock()
+		if retry, ok := s.retries[s.eventKey(event.isLocal, event.relPath)]; ok {
+			retry.due = now.Add(config.RetryPollInterval)
+		}
+		s.retryMu.Unlock()
+	}
+}
+
+// Returns the backoff before the given attempt: exponential with jitter,
+// drawn from the upper half of the window so retries stay spread out.
+func retryBackoff(attempt int) time.Duration {
+	delay := config.RetryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > config.RetryMaxDelay {
+		delay = config.RetryMaxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Reports whether an error will not clear by retrying, such as missing files,
+// denied access, undecryptable content, links refused by the symlink policy,
+// names the destination cannot store or paths that leave the root. Timeouts,
+// interrupted calls, files locked by another process and unclassified errors
+// are retried.
+func isPermanentError(err error) bool {
+	return errors.Is(err, os.ErrNotExist) ||
+		errors.Is(err, os.ErrPermission) ||
+		errors.Is(err, storage.ErrDecryption) ||
+		errors.Is(err, storage.ErrSymlinkSkipped) ||
+		errors.Is(err, storage.ErrIncompatibleName) ||
+		errors.Is(err, storage.ErrUnsafePath) ||
+		errors.Is(err, syscall.ENAMETOOLONG) ||
+		errors.Is(err, syscall.EISDIR) ||
+		errors.Is(err, syscall.ENOTDIR) ||
+		errors.Is(err, syscall.EROFS)
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestRetryBackoffGrowsToCap(t *testing.T) {
+	for attempt := 1; attempt <= 70; attempt++ {
+		window := config.RetryMaxDelay
+		if attempt < 30 {
+			window = min(config.RetryBaseDelay<<(attempt-1), config.RetryMaxDelay)
+		}
+		for range 20 {
+			delay := retryBackoff(attempt)
+			if delay < window/2 || delay > window {
+				t.Fatalf("attempt %d: backoff %s outside [%s, %s]", attempt, delay, window/2, window)
+			}
+		}
+	}
+}
+
+func TestIsPermanentError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{os.ErrNotExist, true},
+		{&fs.PathError{Op: "open", Path: "a", Err: syscall.ENOENT}, true},
+		{fmt.Errorf("copy: %w", os.ErrPermission), true},
+		{fmt.Errorf("read: %w", storage.ErrDecryption), true},
+		{storage.ErrSymlinkSkipped, true},
+		{fmt.Errorf("name: %w", storage.ErrIncompatibleName), true},
+		{storage.ErrUnsafePath, true},
+		{&fs.PathError{Op: "open", Path: "a", Err: syscall.ENAMETOOLONG}, true},
+		{syscall.EISDIR, true},
+		{syscall.ENOTDIR, true},
+		{fmt.Errorf("write: %w", syscall.EROFS), true},
+		{context.DeadlineExceeded, false},
+		{syscall.EINTR, false},
+		{&fs.PathError{Op: "open", Path: "a", Err: syscall.EBUSY}, false},
+		{errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := isPermanentError(tt.err); got != tt.want {
+			t.Errorf("isPermanentError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// Returns a queued write event for a local path.
+func retryEvent(relPath string) queuedEvent {
+	return queuedEvent{
+		raw:     fsnotify.Event{Name: relPath, Op: fsnotify.Write},
+		isLocal: true,
+		relPath: relPath,
+	}
+}
+
+func TestScheduleRetryPromotesToDeadLetters(t *testing.T) {
+	s := newTestEngine(t)
+	event := retryEvent("flaky.txt")
+	transient := errors.New("connection reset")
+
+	for attempt := 1; attempt <= config.MaxRetryAttempts; attempt++ {
+		before := time.Now()
+		s.scheduleRetry(event, transient)
+		if s.PendingRetryCount() != 1 || len(s.ListFailures()) != 0 {
+			t.Fatalf("attempt %d: %d pending, %d failed", attempt, s.PendingRetryCount(), len(s.ListFailures()))
+		}
+		s.retryMu.Lock()
+		due := s.retries[s.eventKey(true, "flaky.txt")].due
+		s.retryMu.Unlock()
+		if !due.After(before) {
+			t.Errorf("attempt %d: retry due at %v, before it was scheduled", attempt, due)
+		}
+	}
+
+	s.scheduleRetry(event, transient)
+	if s.PendingRetryCount() != 0 {
+		t.Errorf("%d retries still pending after the last attempt", s.PendingRetryCount())
+	}
+	failures := s.ListFailures()
+	if len(failures) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(failures))
+	}
+	failure := failures[0]
+	if failure.RelativePath != "flaky.txt" || failure.Location != "local" || failure.Permanent ||
+		failure.Attempts != config.MaxRetryAttempts+1 || failure.LastError != transient.Error() {
+		t.Errorf("dead letter %+v", failure)
+	}
+
+	if requeued := s.RetryFailures(); requeued != 1 || s.PendingRetryCount() != 1 || len(s.ListFailures()) != 0 {
+		t.Errorf("requeued %d: %d pending, %d failed", requeued, s.PendingRetryCount(), len(s.ListFailures()))
+	}
+	s.clearRetry(event)
+	if s.PendingRetryCount() != 0 {
+		t.Error("a synced path is still pending a retry")
+	}
+}
+
+func TestScheduleRetryDeadLettersPermanentErrors(t *testing.T) {
+	s := newTestEngine(t)
+	s.scheduleRetry(retryEvent("locked.txt"), fmt.Errorf("open: %w", os.ErrPermission))
+
+	if s.PendingRetryCount() != 0 {
+		t.Error("a permanent error was scheduled for retry")
+	}
+	failures := s.ListFailures()
+	if len(failures) != 1 || !failures[0].Permanent || failures[0].Attempts != 1 {
+		t.Fatalf("dead letters %+v", failures)
+	}
+}
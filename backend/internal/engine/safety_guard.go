@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Returned by operations refused while the mass-deletion guard is tripped.
+var ErrGuardTripped = errors.New("paused by mass-deletion guard; confirmation required")
+
+// Thresholds for the mass-deletion guard. A zero value disables that check.
+// MaxPercent is only checked once at least MinCount files were deleted.
+type DeletionGuardConfig struct {
+	MaxCount   int
+	MaxPercent float64
+	MinCount   int
+	Window     time.Duration
+}
+
+// Returns the guard thresholds used when none are configured.
+func DefaultDeletionGuardConfig() DeletionGuardConfig {
+	return DeletionGuardConfig{
+		MaxCount:   config.DefaultMassDeleteMaxCount,
+		MaxPercent: config.DefaultMassDeleteMaxPercent,
+		MinCount:   config.DefaultMassDeleteMinCount,
+		Window:     config.DefaultMassDeleteWindow,
+	}
+}
+
+// Tracks recent deletions per source side and whether the guard has tripped.
+type deletionGuard struct {
+	config  DeletionGuardConfig
+	recent  map[bool][]deletionRecord
+	tripped bool
+	reason  string
+}
+
+// Records how many files a single deletion removed.
+type deletionRecord struct {
+	at    time.Time
+	files int
+}
+
+// Sets the thresholds of the mass-deletion guard.
+func (s *SyncEngine) SetDeletionGuard(cfg DeletionGuardConfig) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	s.guard.config = cfg
+}
+
+// Returns why the engine is paused, or an empty string for a plain pause.
+func (s *SyncEngine) PauseReason() string {
+	s.pauseMu.RLock()
+	defer s.pauseMu.RUnlock()
+	return s.guard.reason
+}
+
+// Reports whether the mass-deletion guard is waiting for confirmation.
+func (s *SyncEngine) IsGuardTripped() bool {
+	s.pauseMu.RLock()
+	defer s.pauseMu.RUnlock()
+	return s.guard.tripped
+}
+
+// Checks whether propagating a deletion of relPath from one side stays within
+// the guard thresholds; trips the guard and refuses it otherwise.
+// Callers must hold s.mu.
+func (s *SyncEngine) allowDeletion(isLocal bool, relPath string, srcMap map[string]models.FileMetadata) bool {
+	files := countTree(srcMap, relPath)
+	if files == 0 {
+		// Nothing tracked there, e.g. the echo of a deletion this engine made.
+		return true
+	}
+
+	s.pauseMu.Lock()
+	if s.guard.tripped {
+		s.pauseMu.Unlock()
+		return false
+	}
+	cfg := s.guard.config
+	now := time.Now()
+	records := s.guard.recent[isLocal][:0]
+	deleted := files
+	for _, record := range s.guard.recent[isLocal] {
+		if now.Sub(record.at) < cfg.Window {
+			records = append(records, record)
+			deleted += record.files
+		}
+	}
+
+	tracked := countFiles(srcMap) + deleted - files
+	var reason string
+	switch {
+	case cfg.MaxCount > 0 && deleted > cfg.MaxCount:
+		reason = fmt.Sprintf("%d files deleted on %s within %s (limit %d)", deleted, sideName(isLocal), cfg.Window, cfg.MaxCount)
+	case cfg.MaxPercent > 0 && deleted >= cfg.MinCount && tracked > 0 && float64(deleted)*100/float64(tracked) > cfg.MaxPercent:
+		reason = fmt.Sprintf("%.0f%% of files deleted on %s within %s (limit %.0f%%)", float64(deleted)*100/float64(tracked), sideName(isLocal), cfg.Window, cfg.MaxPercent)
+	}
+	if reason == "" {
+		s.guard.recent[isLocal] = append(records, deletionRecord{at: now, files: files})
+		s.pauseMu.Unlock()
+		return true
+	}
+	s.guard.recent[isLocal] = records
+	s.pauseMu.Unlock()
+
+	s.tripGuard(reason)
+	return false
+}
+
+// Checks a freshly listed state map against the previous one and trips the
+// guard when a root that held files is now empty.
+func (s *SyncEngine) checkRootEmptied(isLocal bool, previous, fresh map[string]models.FileMetadata) error {
+	held := countFiles(previous)
+	if held == 0 || countFiles(fresh) > 0 {
+		return nil
+	}
+	reason := fmt.Sprintf("%s root is empty or missing but previously held %d files", sideName(isLocal), held)
+	s.tripGuard(reason)
+	return fmt.Errorf("%w: %s", ErrGuardTripped, reason)
+}
+
+// Trips the guard when listing a root failed because it no longer exists
+// while it previously held files; otherwise returns the listing error.
+// Callers must hold s.mu.
+func (s *SyncEngine) checkRootMissing(isLocal bool, previous map[string]models.FileMetadata, listErr error) error {
+	if !errors.Is(listErr, os.ErrNotExist) {
+		return listErr
+	}
+	if err := s.checkRootEmptied(isLocal, previous, nil); err != nil {
+		return err
+	}
+	return listErr
+}
+
+// Pauses the engine with a reason and notifies clients.
+func (s *SyncEngine) tripGuard(reason string) {
+	s.pauseMu.Lock()
+	s.isPaused = true
+	s.guard.tripped = true
+	s.guard.reason = reason
+	s.pauseMu.Unlock()
+
+	log.Printf("Mass-deletion guard tripped, sync paused: %s\n", reason)
+	s.publish(Event{
+		Kind:      EventPaused,
+		Direction: "both",
+		Message:   fmt.Sprintf("Sync paused by mass-deletion guard: %s", reason),
+	})
+}
+
+// Confirms the deletions held back by the guard: every tracked file that no
+// longer exists on one side is deleted on the other, then sync resumes.
+func (s *SyncEngine) ConfirmDeletions() error {
+	if !s.IsGuardTripped() {
+		return fmt.Errorf("mass-deletion guard is not tripped")
+	}
+
+	for _, isLocal := range []bool{true, false} {
+		srcProvider, dstProvider := s.getProviders(isLocal)
+
+		// Stat outside the lock so watcher events are not held up.
+		s.mu.RLock()
+		srcMap, _ := s.getStateMaps(isLocal)
+		tracked := make([]string, 0, len(*srcMap))
+		for relPath := range *srcMap {
+			tracked = append(tracked, relPath)
+		}
+		s.mu.RUnlock()
+		// Parents sort first, so a missing directory is handled as a whole.
+		sort.Strings(tracked)
+		var missing []string
+		for _, relPath := range tracked {
+			if _, err := srcProvider.Stat(relPath); errors.Is(err, os.ErrNotExist) {
+				missing = append(missing, relPath)
+			}
+		}
+
+		for _, relPath := range missing {
+			s.mu.Lock()
+			srcMap, dstMap := s.getStateMaps(isLocal)
+			meta, ok := (*srcMap)[relPath]
+			if ok {
+				forgetTree(*srcMap, relPath)
+				forgetTree(*dstMap, relPath)
+			}
+			s.mu.Unlock()
+			if !ok {
+				// Already removed with its parent directory.
+				continue
+			}
+
+			label := entryLabel(meta)
+			if err := s.moveToTrash(dstProvider, relPath); err != nil {
+				log.Printf("error deleting %s %s: %v\n", strings.ToLower(label), relPath, err)
+				continue
+			}
+			s.publish(Event{
+				Kind:      EventDelete,
+				Path:      relPath,
+				Direction: getDirection(isLocal),
+				Message:   fmt.Sprintf("%s deleted: %s", label, relPath),
+			})
+		}
+	}
+
+	s.clearGuard(true)
+	log.Println("Mass deletion confirmed, sync resumed")
+	return nil
+}
+
+// Discards the deletions held back by the guard: files missing on one side
+// are copied back from the other, then sync resumes.
+func (s *SyncEngine) DismissGuard() error {
+	if !s.IsGuardTripped() {
+		return fmt.Errorf("mass-deletion guard is not tripped")
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	// Forget the previous state so the rebuild neither trips the guard again
+	// nor treats the missing files as deleted.
+	s.mu.Lock()
+	s.localMap = make(map[string]models.FileMetadata)
+	s.remoteMap = make(map[string]models.FileMetadata)
+	s.mu.Unlock()
+	if err := s.buildInitialState(); err != nil {
+		return fmt.Errorf("failed to rebuild state: %w", err)
+	}
+	if err := s.reconcile(); err != nil {
+		return fmt.Errorf("failed to reconcile: %w", err)
+	}
+
+	s.clearGuard(false)
+	log.Println("Mass deletion dismissed, missing files restored and sync resumed")
+	return nil
+}
+
+// Clears the guard and resumes sync, replaying the changes recorded while
+// paused or discarding them when a rebuild already covered them.
+func (s *SyncEngine) clearGuard(replay bool) {
+	s.pauseMu.Lock()
+	s.guard.tripped = false
+	s.guard.reason = ""
+	s.guard.recent = make(map[bool][]deletionRecord)
+	if !replay {
+		s.discardPausedLocked()
+	}
+	events, overflow := s.unpauseLocked()
+	s.pauseMu.Unlock()
+	s.replayPaused(events, overflow)
+	s.publish(Event{Kind: EventResumed, Direction: "both", Message: "Sync resumed by mass-deletion guard"})
+}
+
+// Counts tracked files at or below relPath; directories are not counted.
+func countTree(stateMap map[string]models.FileMetadata, relPath string) int {
+	count := 0
+	for tracked, meta := range stateMap {
+		if !meta.IsDir() && (tracked == relPath || strings.HasPrefix(tracked, relPath+"/")) {
+			count++
+		}
+	}
+	return count
+}
+
+// Removes an entry and, for directories, everything below it from a state map.
+func forgetTree(stateMap map[string]models.FileMetadata, relPath string) {
+	delete(stateMap, relPath)
+	for tracked := range stateMap {
+		if strings.HasPrefix(tracked, relPath+"/") {
+			delete(stateMap, tracked)
+		}
+	}
+}
+
+// Returns the side name for an event source.
+func sideName(isLocal bool) string {
+	if isLocal {
+		return "local"
+	}
+	return "remote"
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// Builds a state map of count files named f0, f1, ... under dir.
+func trackedFiles(dir string, count int) map[string]models.FileMetadata {
+	stateMap := make(map[string]models.FileMetadata)
+	if dir != "" {
+		stateMap[dir] = models.FileMetadata{RelativePath: dir, Kind: models.KindDirectory}
+		dir += "/"
+	}
+	for i := range count {
+		relPath := fmt.Sprintf("%sf%d", dir, i)
+		stateMap[relPath] = models.FileMetadata{RelativePath: relPath, Kind: models.KindFile}
+	}
+	return stateMap
+}
+
+func TestAllowDeletionThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DeletionGuardConfig
+		files   int
+		deletes int
+		allowed int
+	}{
+		{"count limit", DeletionGuardConfig{MaxCount: 3, Window: time.Minute}, 10, 5, 3},
+		{"percent limit", DeletionGuardConfig{MaxPercent: 20, MinCount: 1, Window: time.Minute}, 10, 5, 2},
+		{"percent waits for MinCount", DeletionGuardConfig{MaxPercent: 20, MinCount: 5, Window: time.Minute}, 10, 6, 4},
+		{"small tree below MinCount", DeletionGuardConfig{MaxPercent: 50, MinCount: 5, Window: time.Minute}, 4, 4, 4},
+		{"count with a lenient percent", DeletionGuardConfig{MaxCount: 2, MaxPercent: 90, MinCount: 1, Window: time.Minute}, 10, 4, 2},
+		{"disabled", DeletionGuardConfig{}, 10, 10, 10},
+		{"outside the window", DeletionGuardConfig{MaxCount: 1}, 10, 10, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestEngine(t)
+			s.SetDeletionGuard(tt.config)
+			srcMap := trackedFiles("", tt.files)
+
+			allowed := 0
+			for i := range tt.deletes {
+				relPath := fmt.Sprintf("f%d", i)
+				if !s.allowDeletion(true, relPath, srcMap) {
+					break
+				}
+				forgetTree(srcMap, relPath)
+				allowed++
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d deletions, want %d", allowed, tt.allowed)
+			}
+			if tripped := allowed < tt.deletes; s.IsGuardTripped() != tripped {
+				t.Errorf("guard tripped: %v, want %v", s.IsGuardTripped(), tripped)
+			}
+		})
+	}
+}
+
+func TestAllowDeletionCountsDirectoryContents(t *testing.T) {
+	s := newTestEngine(t)
+	s.SetDeletionGuard(DeletionGuardConfig{MaxCount: 3, Window: time.Minute})
+	srcMap := trackedFiles("dir", 4)
+
+	if s.allowDeletion(true, "dir", srcMap) {
+		t.Fatal("deleting a directory of 4 files passed a limit of 3")
+	}
+	if !s.IsGuardTripped() || s.PauseReason() == "" {
+		t.Errorf("guard tripped: %v, reason %q", s.IsGuardTripped(), s.PauseReason())
+	}
+	// The other side keeps its own count, but the tripped guard holds back
+	// every deletion.
+	if s.allowDeletion(false, "dir/f0", srcMap) {
+		t.Error("a tripped guard allowed a deletion")
+	}
+}
+
+func TestAllowDeletionIgnoresUntrackedPaths(t *testing.T) {
+	s := newTestEngine(t)
+	s.SetDeletionGuard(DeletionGuardConfig{MaxCount: 1, Window: time.Minute})
+	srcMap := trackedFiles("", 2)
+	for range 5 {
+		if !s.allowDeletion(true, "gone", srcMap) {
+			t.Fatal("deleting an untracked path was held back")
+		}
+	}
+	if s.IsGuardTripped() {
+		t.Error("untracked deletions tripped the guard")
+	}
+}
+
+func TestCheckRootEmptied(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous map[string]models.FileMetadata
+		fresh    map[string]models.FileMetadata
+		trip     bool
+	}{
+		{"emptied", trackedFiles("", 3), nil, true},
+		{"only directories left", trackedFiles("dir", 3), trackedFiles("dir", 0), true},
+		{"first listing", nil, nil, false},
+		{"held only directories", trackedFiles("dir", 0), nil, false},
+		{"files left", trackedFiles("", 3), trackedFiles("", 1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestEngine(t)
+			err := s.checkRootEmptied(false, tt.previous, tt.fresh)
+			if tt.trip != errors.Is(err, ErrGuardTripped) {
+				t.Errorf("got %v, want tripped %v", err, tt.trip)
+			}
+			if s.IsGuardTripped() != tt.trip {
+				t.Errorf("guard tripped: %v, want %v", s.IsGuardTripped(), tt.trip)
+			}
+		})
+	}
+}
+
+func TestCheckRootMissing(t *testing.T) {
+	s := newTestEngine(t)
+	listErr := errors.New("permission denied")
+	if err := s.checkRootMissing(true, trackedFiles("", 3), listErr); err != listErr || s.IsGuardTripped() {
+		t.Fatalf("unrelated listing error: got %v, tripped %v", err, s.IsGuardTripped())
+	}
+	missing := fmt.Errorf("list root: %w", os.ErrNotExist)
+	if err := s.checkRootMissing(true, trackedFiles("", 3), missing); !errors.Is(err, ErrGuardTripped) {
+		t.Errorf("missing root that held files: got %v", err)
+	}
+}
+
+func TestConfirmDeletions(t *testing.T) {
+	s := newTestEngine(t)
+	for _, relPath := range []string{"kept.txt", "dir/a.txt", "dir/b.txt"} {
+		writeEngineFile(t, s.localProvider, relPath, []byte(relPath))
+		writeEngineFile(t, s.remoteProvider, relPath, []byte(relPath))
+	}
+	if err := s.buildInitialState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmDeletions(); err == nil {
+		t.Error("confirmed deletions without a tripped guard")
+	}
+
+	if err := s.localProvider.DeleteFile("dir"); err != nil {
+		t.Fatal(err)
+	}
+	s.tripGuard("test")
+	withinDeadline(t, "ConfirmDeletions", func() {
+		if err := s.ConfirmDeletions(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if s.IsGuardTripped() || s.IsPaused() {
+		t.Error("sync was not resumed")
+	}
+	if _, err := s.remoteProvider.Stat("dir"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("remote dir was not deleted: %v", err)
+	}
+	if _, err := s.remoteProvider.Stat("kept.txt"); err != nil {
+		t.Errorf("remote kept.txt: %v", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, relPath := range []string{"dir", "dir/a.txt", "dir/b.txt"} {
+		if _, ok := s.localMap[relPath]; ok {
+			t.Errorf("%s is still tracked locally", relPath)
+		}
+		if _, ok := s.remoteMap[relPath]; ok {
+			t.Errorf("%s is still tracked remotely", relPath)
+		}
+	}
+}
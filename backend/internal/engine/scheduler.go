@@ -0,0 +1,316 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configures periodic reconciliation. Cron takes precedence over Interval;
+// with neither set, scheduled syncs are disabled. Runs inside FullScanWindow
+// ("01:00-05:00", may wrap past midnight) rehash every file; runs outside it
+// compare by size and mod time only.
+type SyncSchedule struct {
+	Interval       time.Duration `json:"interval"`
+	Cron           string        `json:"cron,omitempty"`
+	Jitter         time.Duration `json:"jitter"`
+	FullScanWindow string        `json:"fullScanWindow,omitempty"`
+
+	cron   *cronSchedule
+	window *timeWindow
+}
+
+// Reports the state of the scheduler.
+type ScheduleStatus struct {
+	Enabled      bool          `json:"enabled"`
+	Schedule     SyncSchedule  `json:"schedule"`
+	Running      bool          `json:"running"`
+	LastRun      *time.Time    `json:"lastRun,omitempty"`
+	LastRunFull  bool          `json:"lastRunFull"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastError    string        `json:"lastError,omitempty"`
+	NextRun      *time.Time    `json:"nextRun,omitempty"`
+}
+
+// Validates and installs a schedule; the scheduler picks it up immediately.
+func (s *SyncEngine) SetSchedule(schedule SyncSchedule) error {
+	if schedule.Cron != "" {
+		cron, err := parseCron(schedule.Cron)
+		if err != nil {
+			return err
+		}
+		if cron.next(time.Now()).IsZero() {
+			return fmt.Errorf("cron expression %q never matches", schedule.Cron)
+		}
+		schedule.cron = cron
+	}
+	if schedule.FullScanWindow != "" {
+		window, err := parseTimeWindow(schedule.FullScanWindow)
+		if err != nil {
+			return err
+		}
+		schedule.window = window
+	}
+	if schedule.Interval < 0 || schedule.Jitter < 0 {
+		return fmt.Errorf("interval and jitter must not be negative")
+	}
+
+	s.scheduleMu.Lock()
+	s.schedule = schedule
+	s.scheduleMu.Unlock()
+
+	select {
+	case s.scheduleChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Returns the schedule and the times of the last and next scheduled runs.
+func (s *SyncEngine) GetScheduleStatus() ScheduleStatus {
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+	status := s.scheduleStatus
+	status.Schedule = s.schedule
+	status.Enabled = s.schedule.enabled()
+	return status
+}
+
+// Runs scheduled syncs until the engine stops.
+func (s *SyncEngine) runScheduler() {
+	defer s.backgroundWG.Done()
+	for {
+		s.scheduleMu.Lock()
+		schedule := s.schedule
+		s.scheduleStatus.NextRun = nil
+		// A stopped timer never fires, which leaves a disabled schedule waiting
+		// for a change or shutdown.
+		timer := time.NewTimer(time.Hour)
+		timer.Stop()
+		if schedule.enabled() {
+			next := schedule.next(time.Now())
+			s.scheduleStatus.NextRun = &next
+			timer.Reset(time.Until(next))
+		}
+		s.scheduleMu.Unlock()
+
+		select {
+		case <-s.stopCh:
+			timer.Stop()
+			return
+		case <-s.scheduleChanged:
+			timer.Stop()
+		case <-timer.C:
+			s.runScheduledSync(schedule.window.contains(time.Now()))
+		}
+	}
+}
+
+// Runs one scheduled sync unless sync is paused or another sync is running.
+func (s *SyncEngine) runScheduledSync(full bool) {
+	if s.IsPaused() {
+		log.Println("Sync paused, skipping scheduled sync")
+		return
+	}
+	if !s.syncMu.TryLock() {
+		log.Println("Sync already running, skipping scheduled sync")
+		return
+	}
+	defer s.syncMu.Unlock()
+
+	s.scheduleMu.Lock()
+	s.scheduleStatus.Running = true
+	s.scheduleMu.Unlock()
+
+	start := time.Now()
+	err := s.runSync(full)
+
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+	s.scheduleStatus.Running = false
+	s.scheduleStatus.LastRun = &start
+	s.scheduleStatus.LastRunFull = full
+	s.scheduleStatus.LastDuration = time.Since(start)
+	s.scheduleStatus.LastError = ""
+	if err != nil {
+		s.scheduleStatus.LastError = err.Error()
+		log.Printf("error running scheduled sync: %v\n", err)
+	}
+}
+
+// Reports whether the schedule triggers any runs.
+func (schedule SyncSchedule) enabled() bool {
+	return schedule.cron != nil || schedule.Interval > 0
+}
+
+// Returns the time of the next run after now, including jitter.
+func (schedule SyncSchedule) next(now time.Time) time.Time {
+	var next time.Time
+	if schedule.cron != nil {
+		next = schedule.cron.next(now)
+	} else {
+		next = now.Add(schedule.Interval)
+	}
+	if schedule.Jitter > 0 {
+		next = next.Add(rand.N(schedule.Jitter))
+	}
+	return next
+}
+
+// Is a daily time range in minutes after midnight; end before start wraps.
+type timeWindow struct {
+	start, end int
+}
+
+// Parses a window such as "01:00-05:00".
+func parseTimeWindow(spec string) (*timeWindow, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time window %q: expected HH:MM-HH:MM", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %w", spec, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %w", spec, err)
+	}
+	return &timeWindow{start: start, end: end}, nil
+}
+
+// Parses "HH:MM" into minutes after midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Reports whether t falls inside the window. A nil window contains nothing.
+func (w *timeWindow) contains(t time.Time) bool {
+	if w == nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// Is a parsed five-field cron expression: minute hour day-of-month month day-of-week.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+// Parses a cron expression supporting "*", numbers, ranges, lists and steps.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", spec)
+	}
+	// Day-of-week accepts both 0 and 7 for Sunday.
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// Parses one comma-separated cron field into the set of values it matches.
+func parseCronField(field string, low, high int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepSpec)
+			}
+			step = n
+		}
+
+		from, to := low, high
+		if rangeSpec != "*" {
+			first, last, isRange := strings.Cut(rangeSpec, "-")
+			n, err := strconv.Atoi(first)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", first)
+			}
+			from, to = n, n
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				to = high
+			}
+		}
+		if from < low || to > high || from > to {
+			return nil, fmt.Errorf("value %q out of range %d-%d", part, low, high)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// Returns the first matching minute after now, or the zero time when none
+// occurs within the next five years (enough to reach any 29 February).
+// Months, days and hours that do not match are skipped whole.
+func (c *cronSchedule) next(now time.Time) time.Time {
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	loc := t.Location()
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		year, month, day := t.Date()
+		switch {
+		case !c.month[int(month)]:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+		case !c.hour[t.Hour()]:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Reports whether the day of t matches the expression. Like cron, a
+// restricted day-of-month and day-of-week match when either does.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny || c.dowAny:
+		return dom && dow
+	default:
+		return dom || dow
+	}
+}
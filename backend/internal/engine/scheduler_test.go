@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	date := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		now  time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", date(2026, 10, 16, 10, 7), date(2026, 10, 16, 10, 15)},
+		{"*/15 * * * *", date(2026, 10, 16, 10, 45).Add(30 * time.Second), date(2026, 10, 16, 11, 0)},
+		{"0 0 29 2 *", date(2025, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+		{"30 2 * * 1-5", date(2026, 10, 16, 3, 0), date(2026, 10, 19, 2, 30)},
+		{"0 9 * * 7", date(2026, 10, 16, 3, 0), date(2026, 10, 18, 9, 0)},
+		{"0 12 1 * 0", date(2026, 10, 2, 0, 0), date(2026, 10, 4, 12, 0)},
+		{"59 23 31 12 *", date(2026, 12, 31, 23, 59), date(2027, 12, 31, 23, 59)},
+		{"0 0 1 1,7 *", date(2026, 7, 1, 0, 1), date(2027, 1, 1, 0, 0)},
+		{"5 4-6/2 * * *", date(2026, 10, 16, 4, 6), date(2026, 10, 16, 6, 5)},
+		{"0 * * * *", time.Date(2026, 10, 16, 10, 10, 0, 0, ist), time.Date(2026, 10, 16, 11, 0, 0, 0, ist)},
+		{"0 0 31 2 *", date(2026, 1, 1, 0, 0), time.Time{}},
+		{"0 0 30 2 *", date(2026, 1, 1, 0, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		cron, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		if got := cron.next(tt.now); !got.Equal(tt.want) {
+			t.Errorf("%q after %v: got %v, want %v", tt.spec, tt.now, got, tt.want)
+		}
+	}
+}
+
+// Steps one minute at a time, as a reference for next.
+func nextByMinute(c *cronSchedule, now time.Time, limit time.Duration) time.Time {
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	for end := t.Add(limit); t.Before(end); t = t.Add(time.Minute) {
+		if c.minute[t.Minute()] && c.hour[t.Hour()] && c.month[int(t.Month())] && c.dayMatches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func TestCronNextMatchesMinuteSteps(t *testing.T) {
+	locations := []*time.Location{time.UTC, time.FixedZone("NPT", 5*3600+2700)}
+	if newYork, err := time.LoadLocation("America/New_York"); err == nil {
+		locations = append(locations, newYork)
+	}
+	specs := []string{
+		"* * * * *",
+		"17 * * * *",
+		"0 */5 * * *",
+		"30 1,2,3 * * *",
+		"0 0 1 * *",
+		"0 12 13 * 5",
+		"45 23 * 3,11 0",
+		"*/20 9-17 * * 1-5",
+	}
+	for _, loc := range locations {
+		start := time.Date(2026, 2, 27, 22, 13, 0, 0, loc)
+		for _, spec := range specs {
+			cron, err := parseCron(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := start
+			for range 20 {
+				want := nextByMinute(cron, now, 400*24*time.Hour)
+				got := cron.next(now)
+				if !got.Equal(want) {
+					t.Fatalf("%q after %v in %v: got %v, want %v", spec, now, loc, got, want)
+				}
+				// Jump ahead irregularly so runs cross months and DST changes.
+				now = got.Add(time.Duration(len(spec)) * 97 * time.Hour)
+			}
+		}
+	}
+}
+
+func TestCronNextIsFastForRareExpressions(t *testing.T) {
+	cron, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	for range 100 {
+		cron.next(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("100 lookups took %v", elapsed)
+	}
+}
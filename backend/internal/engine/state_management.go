@@ -1,9 +1,16 @@
 package engine
 
 import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/storage"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"sync"
 )
 
 // Ensures that the local and remote folders exist.
@@ -17,73 +24,215 @@ func (s *SyncEngine) ensureFolderExists() error {
 	return nil
 }
 
-// Builds the initial state maps for local and remote storage.
+// Builds the initial state maps for local and remote storage, listing both
+// sides concurrently. A root that suddenly lists empty trips the
+// mass-deletion guard and keeps the previous maps. Listing is cheap; hashes
+// are carried over from the previous maps for files whose size and mod time
+// have not changed, and computed later only when needed.
 func (s *SyncEngine) buildInitialState() error {
-	localMap, err := s.localProvider.BuildStateMap()
-	if err != nil {
+	log.Println("Building initial state maps for Local and Remote...")
+	var localMap, remoteMap map[string]models.FileMetadata
+	var localErr, remoteErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		localMap, localErr = s.localProvider.List()
+	}()
+	go func() {
+		defer wg.Done()
+		remoteMap, remoteErr = s.remoteProvider.List()
+	}()
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if localErr != nil {
+		return s.checkRootMissing(true, s.localMap, localErr)
+	}
+	if remoteErr != nil {
+		return fmt.Errorf("failed to build remote state map: %w", s.checkRootMissing(false, s.remoteMap, remoteErr))
+	}
+	dropHidden(localMap)
+	dropHidden(remoteMap)
+	if err := s.checkRootEmptied(true, s.localMap, localMap); err != nil {
 		return err
 	}
-	s.localMap = localMap
-	log.Printf("...Local map built with %d files.", len(s.localMap))
-
-	log.Println("Building initial state map for Remote...")
-	remoteMap, err := s.remoteProvider.BuildStateMap()
-	if err != nil {
-		return fmt.Errorf("failed to build remote state map: %w", err)
+	if err := s.checkRootEmptied(false, s.remoteMap, remoteMap); err != nil {
+		return err
 	}
+	carryHashes(localMap, s.localMap)
+	carryHashes(remoteMap, s.remoteMap)
+	s.localMap = localMap
 	s.remoteMap = remoteMap
-	log.Printf("...Remote map built with %d files.", len(s.remoteMap))
+	log.Printf("...Local map built with %d files, Remote map with %d files.", countFiles(localMap), countFiles(remoteMap))
 
 	return nil
 }
 
-// Reconciles differences between local and remote storage.
-func (s *SyncEngine) reconcile() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Copies known hashes from a previous state map into a freshly listed one
+// for entries whose size and mod time are unchanged.
+func carryHashes(fresh, previous map[string]models.FileMetadata) {
+	for relPath, meta := range fresh {
+		fresh[relPath] = reuseHash(meta, previous)
+	}
+}
+
+// Fills in the hash of meta from a previous state map when the entry is
+// unchanged there.
+func reuseHash(meta models.FileMetadata, previous map[string]models.FileMetadata) models.FileMetadata {
+	if meta.HasHash() {
+		return meta
+	}
+	old, ok := previous[meta.RelativePath]
+	if !ok || !old.HasHash() || old.Size != meta.Size || !old.ModTime.Equal(meta.ModTime) {
+		return meta
+	}
+	meta.Hash = old.Hash
+	return meta
+}
+
+// Reports whether the source and destination entries hold the same content.
+// Hashes are only requested from the providers when size and mod time cannot
+// decide; computed hashes are stored back into the given metadata.
+func contentMatches(src, dst storage.StorageProvider, srcMeta, dstMeta *models.FileMetadata) (bool, error) {
+	if same, known := srcMeta.Matches(*dstMeta); known {
+		return same, nil
+	}
+	if !srcMeta.HasHash() {
+		hash, err := src.Hash(srcMeta.RelativePath)
+		if err != nil {
+			return false, err
+		}
+		srcMeta.Hash = hash
+	}
+	if !dstMeta.HasHash() {
+		hash, err := dst.Hash(dstMeta.RelativePath)
+		if err != nil {
+			return false, err
+		}
+		dstMeta.Hash = hash
+	}
+	return srcMeta.Hash == dstMeta.Hash, nil
+}
 
-	// Check for files that exist locally but not remotely
+// Recomputes the hashes of all files present on both sides with equal sizes,
+// so reconciliation compares content rather than mod times. Hashing runs on
+// a bounded pool without holding s.mu; results are dropped for entries
+// changed meanwhile.
+func (s *SyncEngine) rehashShared() error {
+	s.mu.RLock()
+	var shared []models.FileMetadata
 	for relPath, localMeta := range s.localMap {
-		remoteMeta, existsInRemote := s.remoteMap[relPath]
+		if remoteMeta, ok := s.remoteMap[relPath]; ok && localMeta.IsFile() && remoteMeta.IsFile() && remoteMeta.Size == localMeta.Size {
+			shared = append(shared, localMeta, remoteMeta)
+		}
+	}
+	s.mu.RUnlock()
 
-		if !existsInRemote {
-			log.Printf("File %s exists locally but not remotely. Copying to remote...\n", relPath)
-			if err := copyFile(s.localProvider, s.remoteProvider, relPath, localMeta.ModTime); err != nil {
-				return fmt.Errorf("error copying file %s to remote: %w", relPath, err)
-			}
-			s.remoteMap[relPath] = localMeta
-			log.Printf("File %s copied to remote successfully.\n", relPath)
-			continue
+	return parallelEach(len(shared)/2, config.ScanHashWorkers, func(i int) error {
+		localMeta, remoteMeta := shared[2*i], shared[2*i+1]
+		s.throttleScan(localMeta.Size + remoteMeta.Size)
+		localHash, err := s.localProvider.Hash(localMeta.RelativePath)
+		if err != nil {
+			return err
+		}
+		remoteHash, err := s.remoteProvider.Hash(remoteMeta.RelativePath)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if current, ok := s.localMap[localMeta.RelativePath]; ok && sameVersion(current, localMeta) {
+			current.Hash = localHash
+			s.localMap[localMeta.RelativePath] = current
+		}
+		if current, ok := s.remoteMap[remoteMeta.RelativePath]; ok && sameVersion(current, remoteMeta) {
+			current.Hash = remoteHash
+			s.remoteMap[remoteMeta.RelativePath] = current
 		}
-		if localMeta.Hash != remoteMeta.Hash {
-			if localMeta.ModTime.After(remoteMeta.ModTime) {
-				log.Printf("File %s is newer locally. Updating remote file...\n", relPath)
-				if err := copyFile(s.localProvider, s.remoteProvider, relPath, localMeta.ModTime); err != nil {
-					return fmt.Errorf("error updating file %s to remote: %w", relPath, err)
+		return nil
+	})
+}
+
+// Calls fn for each index below n from at most workers goroutines at once.
+// No new calls start after the first error, which is returned.
+func parallelEach(n, workers int, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		next     int
+	)
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if firstErr != nil || next >= n {
+					mu.Unlock()
+					return
 				}
-				s.remoteMap[relPath] = localMeta
-				log.Printf("File %s updated successfully.\n", relPath)
-			} else {
-				log.Printf("File %s is newer remotely. Updating local file...\n", relPath)
-				if err := copyFile(s.remoteProvider, s.localProvider, relPath, remoteMeta.ModTime); err != nil {
-					return fmt.Errorf("error updating file %s to local: %w", relPath, err)
+				i := next
+				next++
+				mu.Unlock()
+
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
 				}
-				s.localMap[relPath] = remoteMeta
-				log.Printf("File %s updated successfully.\n", relPath)
 			}
-		}
+		}()
 	}
+	wg.Wait()
+	return firstErr
+}
 
-	// Check for files that exist remotely but not locally
-	for relPath, remoteMeta := range s.remoteMap {
-		if _, existsInLocal := s.localMap[relPath]; !existsInLocal {
-			log.Printf("File %s exists remotely but not locally. Copying to local...\n", relPath)
-			if err := copyFile(s.remoteProvider, s.localProvider, relPath, remoteMeta.ModTime); err != nil {
-				return fmt.Errorf("error copying file %s to local: %w", relPath, err)
-			}
-			s.localMap[relPath] = remoteMeta
-			log.Printf("File %s copied to local successfully.\n", relPath)
+// Reports whether two entries describe the same size and mod time.
+func sameVersion(a, b models.FileMetadata) bool {
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
+
+// Reconciles differences between local and remote storage.
+func (s *SyncEngine) reconcile() error {
+	return s.reconcileJob(context.Background(), nil)
+}
+
+// Reconciles both sides, reporting progress to job when it is not nil and
+// stopping between files once ctx is cancelled. Comparison works on a copy
+// of the state maps and copies run on the worker pool under per-file locks,
+// so s.mu is only held briefly and API reads stay responsive.
+func (s *SyncEngine) reconcileJob(ctx context.Context, job *syncJob) error {
+	s.setJobPhase(job, PhaseComparing)
+	s.mu.RLock()
+	localMap, remoteMap := maps.Clone(s.localMap), maps.Clone(s.remoteMap)
+	s.mu.RUnlock()
+
+	actions, err := s.planActions(localMap, remoteMap)
+	if err != nil {
+		return err
+	}
+	actions, conflicts := s.filterNames(actions, localMap, remoteMap)
+	for _, conflict := range conflicts {
+		s.reportNameConflict(conflict.RelativePath, conflict.Direction == getDirection(true), conflict.err)
+	}
+	s.mu.Lock()
+	carryHashes(s.localMap, localMap)
+	carryHashes(s.remoteMap, remoteMap)
+	s.mu.Unlock()
+
+	s.setJobTotals(job, actions)
+	s.setJobPhase(job, PhaseTransferring)
+	if err := s.dispatchActions(ctx, actions, job); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Println("Reconciliation cancelled.")
 		}
+		return err
 	}
 
 	log.Println("Reconciliation complete.")
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Returned by transfers aborted because shutdown ran out of time.
+var ErrTransferAborted = errors.New("transfer aborted by shutdown")
+
+// Describes a file transfer in progress.
+type Transfer struct {
+	ID             string    `json:"id"`
+	RelativePath   string    `json:"relativePath"`
+	Direction      string    `json:"direction"`
+	BytesCopied    int64     `json:"bytesCopied"`
+	TotalBytes     int64     `json:"totalBytes"`
+	BytesPerSecond float64   `json:"bytesPerSecond"`
+	StartedAt      time.Time `json:"startedAt"`
+	LastProgressAt time.Time `json:"lastProgressAt"`
+}
+
+// Tracks the progress of one transfer, reports it at a limited rate and
+// throttles it to the configured bandwidth.
+type transfer struct {
+	mu        sync.Mutex
+	info      Transfer
+	lastEvent time.Time
+	notify    func(Transfer)
+	limiters  []*tokenBucket
+	abort     <-chan struct{}
+}
+
+// Counts bytes read from a transfer's source.
+type progressReader struct {
+	reader   io.Reader
+	transfer *transfer
+}
+
+// Lists the transfers in progress, oldest first.
+func (s *SyncEngine) ListTransfers() []Transfer {
+	s.transfersMu.Lock()
+	defer s.transfersMu.Unlock()
+	transfers := make([]Transfer, 0, len(s.transfers))
+	for _, t := range s.transfers {
+		transfers = append(transfers, t.snapshot())
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].StartedAt.Before(transfers[j].StartedAt)
+	})
+	return transfers
+}
+
+// Copies a file like copyFile while publishing its progress as a transfer.
+func (s *SyncEngine) copyWithProgress(src, dst storage.StorageProvider, relPath string, modTime time.Time, size int64, isLocal bool) (int64, error) {
+	now := time.Now()
+	t := &transfer{
+		info: Transfer{
+			ID:             strconv.FormatUint(s.lastTransfer.Add(1), 10),
+			RelativePath:   relPath,
+			Direction:      getDirection(isLocal),
+			TotalBytes:     size,
+			StartedAt:      now,
+			LastProgressAt: now,
+		},
+		lastEvent: now,
+		notify:    s.emitTransferProgress,
+		limiters:  s.transferLimiters(isLocal),
+		abort:     s.abortCh,
+	}
+
+	s.transfersMu.Lock()
+	s.transfers[t.info.ID] = t
+	s.transfersMu.Unlock()
+	defer func() {
+		s.transfersMu.Lock()
+		delete(s.transfers, t.info.ID)
+		s.transfersMu.Unlock()
+	}()
+
+	return copyFile(src, dst, relPath, modTime, t)
+}
+
+// Sends a progress event for a transfer.
+func (s *SyncEngine) emitTransferProgress(info Transfer) {
+	s.publish(Event{
+		Kind:           EventProgress,
+		Path:           info.RelativePath,
+		Direction:      info.Direction,
+		Size:           info.BytesCopied,
+		TotalBytes:     info.TotalBytes,
+		BytesPerSecond: info.BytesPerSecond,
+		Duration:       info.LastProgressAt.Sub(info.StartedAt),
+		Message: fmt.Sprintf("Transferring %s: %d/%d bytes (%.0f B/s)",
+			info.RelativePath, info.BytesCopied, info.TotalBytes, info.BytesPerSecond),
+	})
+}
+
+// Wraps a source reader so reads advance the transfer. A nil transfer
+// returns the reader unchanged.
+func (t *transfer) wrap(reader io.Reader) io.Reader {
+	if t == nil {
+		return reader
+	}
+	return &progressReader{reader: reader, transfer: t}
+}
+
+// Records copied bytes and notifies at most once per progress interval.
+func (t *transfer) add(n int64) {
+	t.mu.Lock()
+	now := time.Now()
+	t.info.BytesCopied += n
+	t.info.LastProgressAt = now
+	if elapsed := now.Sub(t.info.StartedAt).Seconds(); elapsed > 0 {
+		t.info.BytesPerSecond = float64(t.info.BytesCopied) / elapsed
+	}
+	if now.Sub(t.lastEvent) < config.ProgressEventInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastEvent = now
+	info := t.info
+	t.mu.Unlock()
+	t.notify(info)
+}
+
+// Returns a copy of the transfer's public state.
+func (t *transfer) snapshot() Transfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.info
+}
+
+// Reads from the source, waits for bandwidth and advances the transfer.
+// Fails once transfers are aborted so the destination is discarded.
+func (r *progressReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.transfer.abort:
+		return 0, ErrTransferAborted
+	default:
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		for _, limiter := range r.transfer.limiters {
+			limiter.wait(int64(n), r.transfer.abort)
+		}
+		r.transfer.add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferProgressEvent(t *testing.T) {
+	s := newTestEngine(t)
+	sub := s.Subscribe("test", 10)
+	defer sub.Close()
+
+	started := time.Now().Add(-2 * time.Second)
+	tr := &transfer{
+		info: Transfer{
+			ID:           "1",
+			RelativePath: "big.iso",
+			Direction:    getDirection(true),
+			TotalBytes:   1000,
+			StartedAt:    started,
+		},
+		lastEvent: started,
+		notify:    s.emitTransferProgress,
+	}
+	tr.add(400)
+
+	select {
+	case event := <-sub.Events():
+		if event.Kind != EventProgress || event.Path != "big.iso" {
+			t.Fatalf("published %+v", event)
+		}
+		if event.Size != 400 || event.TotalBytes != 1000 {
+			t.Errorf("progress reports %d of %d bytes", event.Size, event.TotalBytes)
+		}
+		if event.BytesPerSecond < 150 || event.BytesPerSecond > 200 {
+			t.Errorf("progress reports %.0f bytes per second", event.BytesPerSecond)
+		}
+	default:
+		t.Fatal("no progress event published")
+	}
+
+	// A second update within the interval is not reported.
+	tr.add(100)
+	select {
+	case event := <-sub.Events():
+		t.Errorf("published %+v within the progress interval", event)
+	default:
+	}
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/storage"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the hidden directory, inside each root, that holds deleted files.
+const trashDir = ".sync-trash"
+
+// Describes a file in the trash.
+// The ID is the deletion batch timestamp followed by the original path.
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	RelativePath string    `json:"relativePath"`
+	Location     string    `json:"location"`
+	Size         int64     `json:"size"`
+	DeletedAt    time.Time `json:"deletedAt"`
+}
+
+// Returned when a requested trash entry does not exist.
+var ErrTrashEntryNotFound = errors.New("trash entry not found")
+
+// Sets how long deleted files stay in the trash before being purged.
+func (s *SyncEngine) SetTrashRetention(retention time.Duration) {
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	s.trashRetention = retention
+}
+
+// Moves a file or directory into the trash of its root instead of deleting it.
+func (s *SyncEngine) moveToTrash(provider storage.StorageProvider, relPath string) error {
+	if _, err := provider.Stat(relPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat %s before trashing: %w", relPath, err)
+	}
+	batch := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := moveWithin(provider, relPath, path.Join(trashDir, batch, relPath)); err != nil {
+		return fmt.Errorf("failed to move %s to trash: %w", relPath, err)
+	}
+	return nil
+}
+
+// Lists the trash of both roots, most recently deleted first.
+func (s *SyncEngine) ListTrash() ([]TrashEntry, error) {
+	var entries []TrashEntry
+	for side, provider := range s.providersBySide() {
+		sideEntries, err := listTrash(provider, side)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, sideEntries...)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// Moves a trashed file back to its original path and syncs it to the other side.
+// An existing file at that path is kept as a prior version.
+func (s *SyncEngine) RestoreFromTrash(location, id string) error {
+	entries, err := s.ListTrash()
+	if err != nil {
+		return err
+	}
+	var entry *TrashEntry
+	for i := range entries {
+		if entries[i].ID == id && entries[i].Location == location {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("%w: %s on %s", ErrTrashEntryNotFound, id, location)
+	}
+
+	relPath := entry.RelativePath
+	lock := s.lockFor(relPath)
+	lock.Lock()
+	defer lock.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isLocal := location == "local"
+	srcProvider, dstProvider := s.getProviders(isLocal)
+	srcMap, dstMap := s.getStateMaps(isLocal)
+
+	if err := s.archiveVersion(srcProvider, relPath); err != nil {
+		return err
+	}
+	if err := moveWithin(srcProvider, path.Join(trashDir, entry.ID), relPath); err != nil {
+		return fmt.Errorf("failed to restore %s from trash: %w", relPath, err)
+	}
+	meta, err := srcProvider.Stat(relPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat restored %s: %w", relPath, err)
+	}
+	(*srcMap)[relPath] = meta
+	log.Printf("Restored %s from %s trash\n", relPath, location)
+
+	s.publish(Event{
+		Kind:      EventRestore,
+		Path:      relPath,
+		OldPath:   path.Join(trashDir, entry.ID),
+		Direction: getDirection(isLocal),
+		Size:      meta.Size,
+		Message:   fmt.Sprintf("File restored from trash: %s", relPath),
+	})
+
+	return s.syncFileToDestination(srcProvider, dstProvider, srcMap, dstMap, relPath, meta, isLocal)
+}
+
+// Permanently deletes everything in the trash of both roots.
+func (s *SyncEngine) EmptyTrash() error {
+	for side, provider := range s.providersBySide() {
+		if err := provider.DeleteFile(trashDir); err != nil {
+			return fmt.Errorf("failed to empty %s trash: %w", side, err)
+		}
+	}
+	log.Println("Trash emptied")
+	return nil
+}
+
+// Permanently deletes trash batches older than the configured retention.
+func (s *SyncEngine) purgeTrash() {
+	s.trashMu.Lock()
+	retention := s.trashRetention
+	s.trashMu.Unlock()
+	if retention <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-retention)
+	for side, provider := range s.providersBySide() {
+		entries, err := listTrash(provider, side)
+		if err != nil {
+			log.Printf("error listing %s trash: %v\n", side, err)
+			continue
+		}
+		purged := make(map[string]bool)
+		for _, entry := range entries {
+			batch := strconv.FormatInt(entry.DeletedAt.UnixNano(), 10)
+			if purged[batch] || !entry.DeletedAt.Before(cutoff) {
+				continue
+			}
+			if err := provider.DeleteFile(path.Join(trashDir, batch)); err != nil {
+				log.Printf("error purging %s trash batch %s: %v\n", side, batch, err)
+				continue
+			}
+			purged[batch] = true
+		}
+		if len(purged) > 0 {
+			log.Printf("Purged %d expired trash batches from %s\n", len(purged), side)
+		}
+	}
+}
+
+// Periodically purges expired trash until the engine stops.
+func (s *SyncEngine) runTrashPurger() {
+	defer s.backgroundWG.Done()
+	ticker := time.NewTicker(config.TrashPurgeInterval)
+	defer ticker.Stop()
+	s.purgeTrash()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.purgeTrash()
+		}
+	}
+}
+
+// Lists the files in a provider's trash.
+func listTrash(provider storage.StorageProvider, location string) ([]TrashEntry, error) {
+	stored, err := provider.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	var entries []TrashEntry
+	for storedPath, meta := range stored {
+		id, ok := strings.CutPrefix(storedPath, trashDir+"/")
+		if !ok || meta.IsDir() {
+			continue
+		}
+		batch, relPath, ok := strings.Cut(id, "/")
+		if !ok {
+			continue
+		}
+		nanos, err := strconv.ParseInt(batch, 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, TrashEntry{
+			ID:           id,
+			RelativePath: relPath,
+			Location:     location,
+			Size:         meta.Size,
+			DeletedAt:    time.Unix(0, nanos),
+		})
+	}
+	return entries, nil
+}
+
+// Moves a file or directory within a provider, renaming in place when the
+// provider supports it and copying otherwise.
+func moveWithin(provider storage.StorageProvider, from, to string) error {
+	if mover, ok := provider.(storage.Mover); ok {
+		return mover.Move(from, to)
+	}
+
+	entries, err := provider.List()
+	if err != nil {
+		return err
+	}
+	for storedPath, meta := range entries {
+		if meta.IsDir() || storedPath != from && !strings.HasPrefix(storedPath, from+"/") {
+			continue
+		}
+		target := to + strings.TrimPrefix(storedPath, from)
+		if _, err := fullCopyWithin(provider, storedPath, target, meta.ModTime); err != nil {
+			return err
+		}
+	}
+	return provider.DeleteFile(from)
+}
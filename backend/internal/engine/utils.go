@@ -20,13 +20,15 @@ func (s *SyncEngine) determineEventSource(absPath string) (bool, string, error)
 	// Try local root
 	rel, err := filepath.Rel(localRoot, cleanPath)
 	if err == nil && !strings.HasPrefix(rel, "..") {
-		return true, filepath.ToSlash(rel), nil
+		logical, err := logicalPath(s.localProvider, filepath.ToSlash(rel))
+		return true, logical, err
 	}
 
 	// Try remote root
 	rel, err = filepath.Rel(remoteRoot, cleanPath)
 	if err == nil && !strings.HasPrefix(rel, "..") {
-		return false, filepath.ToSlash(rel), nil
+		logical, err := logicalPath(s.remoteProvider, filepath.ToSlash(rel))
+		return false, logical, err
 	}
 
 	return false, "", fmt.Errorf("unrecognized event source: %s", absPath)
@@ -48,6 +50,14 @@ func (s *SyncEngine) getProviders(isLocal bool) (storage.StorageProvider, storag
 	return s.remoteProvider, s.localProvider
 }
 
+// Returns both providers keyed by side name.
+func (s *SyncEngine) providersBySide() map[string]storage.StorageProvider {
+	return map[string]storage.StorageProvider{
+		"local":  s.localProvider,
+		"remote": s.remoteProvider,
+	}
+}
+
 // Returns source and destination state maps based on event source.
 func (s *SyncEngine) getStateMaps(isLocal bool) (*map[string]models.FileMetadata, *map[string]models.FileMetadata) {
 	if isLocal {
@@ -77,12 +87,56 @@ func (s *SyncEngine) whichSideAndRel(absPath string) (bool, string) {
 	}
 
 	if ok, rel := resolve(localRoot); ok {
-		return true, rel
+		return true, s.logicalOrEmpty(s.localProvider, rel)
 	}
 	if ok, rel := resolve(remoteRoot); ok {
-		return false, rel
+		return false, s.logicalOrEmpty(s.remoteProvider, rel)
 	}
 
 	log.Printf("unable to map path %s to local (%s) or remote (%s) roots", absPath, localRoot, remoteRoot)
 	return false, ""
 }
+
+// Maps a stored relative path to its logical path for providers that rename entries.
+func logicalPath(provider storage.StorageProvider, rel string) (string, error) {
+	mapper, ok := provider.(storage.PathMapper)
+	if !ok || rel == "" {
+		return rel, nil
+	}
+	logical, err := mapper.LogicalPath(rel)
+	if err != nil {
+		return "", fmt.Errorf("unable to map stored path %s: %w", rel, err)
+	}
+	return logical, nil
+}
+
+// Maps a stored relative path to its logical path, returning an empty path when
+// the entry does not belong to the provider.
+func (s *SyncEngine) logicalOrEmpty(provider storage.StorageProvider, rel string) string {
+	logical, err := logicalPath(provider, rel)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return logical
+}
+
+// Reports whether any component of a relative path is hidden. Hidden entries
+// hold engine bookkeeping such as version history and are never synced.
+func isHiddenPath(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes hidden entries from a state map.
+func dropHidden(stateMap map[string]models.FileMetadata) {
+	for relPath := range stateMap {
+		if isHiddenPath(relPath) {
+			delete(stateMap, relPath)
+		}
+	}
+}
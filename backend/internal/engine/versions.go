@@ -0,0 +1,344 @@
+package engine
+
+import (
+	"backend/internal/config"
+	"backend/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the hidden directory, inside each root, that holds prior versions.
+const versionsDir = ".sync-versions"
+
+// Controls how many prior versions are kept.
+type VersionRetention struct {
+	KeepLast      int
+	KeepDailyDays int
+	MaxTotalBytes int64
+}
+
+// Describes a stored prior version of a file.
+type FileVersion struct {
+	ID           string    `json:"id"`
+	RelativePath string    `json:"relativePath"`
+	Location     string    `json:"location"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime"`
+	ArchivedAt   time.Time `json:"archivedAt"`
+}
+
+// Returned when a requested version does not exist.
+var ErrVersionNotFound = errors.New("version not found")
+
+// Returns the retention used when none is configured.
+func DefaultVersionRetention() VersionRetention {
+	return VersionRetention{
+		KeepLast:      config.DefaultVersionKeepLast,
+		KeepDailyDays: config.DefaultVersionKeepDailyDays,
+		MaxTotalBytes: config.DefaultVersionMaxTotalBytes,
+	}
+}
+
+// Sets the retention rules applied after each archived version.
+func (s *SyncEngine) SetVersionRetention(retention VersionRetention) {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+	s.versionRetention = retention
+}
+
+// Copies the current content of a file into the versions area of its root
+// before it is overwritten. Missing files are ignored.
+func (s *SyncEngine) archiveVersion(provider storage.StorageProvider, relPath string) error {
+	if err := s.storeVersion(provider, relPath); err != nil {
+		return err
+	}
+	if err := s.pruneVersions(provider, relPath); err != nil {
+		log.Printf("error pruning versions of %s: %v\n", relPath, err)
+	}
+	return nil
+}
+
+// Copies the current content of a file into the versions area without
+// applying retention.
+func (s *SyncEngine) storeVersion(provider storage.StorageProvider, relPath string) error {
+	meta, err := provider.Stat(relPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat %s for versioning: %w", relPath, err)
+	}
+
+	versionID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	n, err := fullCopyWithin(provider, relPath, versionPath(relPath, versionID), meta.ModTime)
+	if err != nil {
+		return fmt.Errorf("failed to store version of %s: %w", relPath, err)
+	}
+
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+	if index, ok := s.versionIndexes[provider]; ok {
+		nanos, _ := strconv.ParseInt(versionID, 10, 64)
+		index.add(FileVersion{
+			ID:           versionID,
+			RelativePath: relPath,
+			Size:         n,
+			ModTime:      meta.ModTime,
+			ArchivedAt:   time.Unix(0, nanos),
+		})
+	}
+	return nil
+}
+
+// Lists stored versions of a file on both sides, newest first.
+func (s *SyncEngine) ListVersions(relPath string) ([]FileVersion, error) {
+	if err := storage.ValidatePath(relPath); err != nil {
+		return nil, err
+	}
+	var versions []FileVersion
+	for side, provider := range s.providersBySide() {
+		sideVersions, err := listVersions(provider, path.Join(versionsDir, relPath), side)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, sideVersions[relPath]...)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ArchivedAt.After(versions[j].ArchivedAt)
+	})
+	return versions, nil
+}
+
+// Restores a prior version of a file on the side that holds it and syncs
+// the result to the other side like any other change.
+func (s *SyncEngine) RestoreVersion(relPath, versionID string) error {
+	if err := storage.ValidatePath(relPath); err != nil {
+		return err
+	}
+	lock := s.lockFor(relPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	versions, err := s.ListVersions(relPath)
+	if err != nil {
+		return err
+	}
+	var version *FileVersion
+	for i := range versions {
+		if versions[i].ID == versionID {
+			version = &versions[i]
+			break
+		}
+	}
+	if version == nil {
+		return fmt.Errorf("%w: %s of %s", ErrVersionNotFound, versionID, relPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isLocal := version.Location == "local"
+	srcProvider, dstProvider := s.getProviders(isLocal)
+	srcMap, dstMap := s.getStateMaps(isLocal)
+
+	// Keep the content being replaced so the restore can be undone. Retention
+	// runs afterwards so it cannot remove the version being restored.
+	if err := s.storeVersion(srcProvider, relPath); err != nil {
+		return err
+	}
+	// Restored content is stamped as new so conflict resolution keeps it.
+	if _, err := fullCopyWithin(srcProvider, versionPath(relPath, versionID), relPath, time.Now()); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", relPath, err)
+	}
+	if err := s.pruneVersions(srcProvider, relPath); err != nil {
+		log.Printf("error pruning versions of %s: %v\n", relPath, err)
+	}
+	meta, err := srcProvider.Stat(relPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat restored %s: %w", relPath, err)
+	}
+	(*srcMap)[relPath] = meta
+	log.Printf("Restored %s to version %s on %s\n", relPath, versionID, version.Location)
+
+	s.publish(Event{
+		Kind:      EventRestore,
+		Path:      relPath,
+		OldPath:   versionPath(relPath, versionID),
+		Direction: getDirection(isLocal),
+		Size:      meta.Size,
+		Message:   fmt.Sprintf("File restored: %s (version %s)", relPath, versionID),
+	})
+
+	return s.syncFileToDestination(srcProvider, dstProvider, srcMap, dstMap, relPath, meta, isLocal)
+}
+
+// Applies retention rules to the versions of relPath and to the total size
+// of the versions area of the provider. Versions are looked up in the index
+// of the provider, so only the first run lists the versions area.
+func (s *SyncEngine) pruneVersions(provider storage.StorageProvider, relPath string) error {
+	s.versionMu.Lock()
+	retention := s.versionRetention
+	index, err := s.versionIndexLocked(provider)
+	if err != nil {
+		s.versionMu.Unlock()
+		return err
+	}
+
+	now := time.Now()
+	var doomed []FileVersion
+	days := make(map[string]bool)
+	for i, version := range index.versions[relPath] {
+		day := version.ArchivedAt.Format("2006-01-02")
+		daily := now.Sub(version.ArchivedAt) < time.Duration(retention.KeepDailyDays)*24*time.Hour && !days[day]
+		if i >= retention.KeepLast && !daily {
+			doomed = append(doomed, version)
+		}
+		days[day] = true
+	}
+	for _, version := range doomed {
+		index.remove(version)
+	}
+
+	if retention.MaxTotalBytes > 0 && index.total > retention.MaxTotalBytes {
+		var remaining []FileVersion
+		for _, versions := range index.versions {
+			remaining = append(remaining, versions...)
+		}
+		sort.Slice(remaining, func(i, j int) bool {
+			return remaining[i].ArchivedAt.Before(remaining[j].ArchivedAt)
+		})
+		for _, version := range remaining {
+			if index.total <= retention.MaxTotalBytes {
+				break
+			}
+			index.remove(version)
+			doomed = append(doomed, version)
+		}
+	}
+	s.versionMu.Unlock()
+
+	for _, version := range doomed {
+		if err := provider.DeleteFile(versionPath(version.RelativePath, version.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tracks the versions stored in one root, grouped by file and newest first,
+// along with their total size.
+type versionIndex struct {
+	versions map[string][]FileVersion
+	total    int64
+}
+
+// Returns the version index of a provider, listing its versions area the
+// first time. The caller holds versionMu.
+func (s *SyncEngine) versionIndexLocked(provider storage.StorageProvider) (*versionIndex, error) {
+	if index, ok := s.versionIndexes[provider]; ok {
+		return index, nil
+	}
+	versions, err := listVersions(provider, versionsDir, "")
+	if err != nil {
+		return nil, err
+	}
+	index := &versionIndex{versions: versions}
+	for _, list := range versions {
+		for _, version := range list {
+			index.total += version.Size
+		}
+	}
+	s.versionIndexes[provider] = index
+	return index, nil
+}
+
+// Records a newly stored version, which is newer than any other.
+func (idx *versionIndex) add(version FileVersion) {
+	idx.versions[version.RelativePath] = append([]FileVersion{version}, idx.versions[version.RelativePath]...)
+	idx.total += version.Size
+}
+
+// Forgets a version.
+func (idx *versionIndex) remove(version FileVersion) {
+	list := idx.versions[version.RelativePath]
+	for i := range list {
+		if list[i].ID == version.ID {
+			list = append(list[:i:i], list[i+1:]...)
+			idx.total -= version.Size
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(idx.versions, version.RelativePath)
+	} else {
+		idx.versions[version.RelativePath] = list
+	}
+}
+
+// Lists the versions stored below dir, a directory inside the versions area,
+// grouped by file, newest first.
+func listVersions(provider storage.StorageProvider, dir, location string) (map[string][]FileVersion, error) {
+	entries, err := storage.ListTree(provider, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	versions := make(map[string][]FileVersion)
+	for storedPath, meta := range entries {
+		rest, ok := strings.CutPrefix(storedPath, versionsDir+"/")
+		if !ok || meta.IsDir() {
+			continue
+		}
+		relPath, id := path.Split(rest)
+		relPath = strings.TrimSuffix(relPath, "/")
+		nanos, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || relPath == "" {
+			continue
+		}
+		versions[relPath] = append(versions[relPath], FileVersion{
+			ID:           id,
+			RelativePath: relPath,
+			Location:     location,
+			Size:         meta.Size,
+			ModTime:      meta.ModTime,
+			ArchivedAt:   time.Unix(0, nanos),
+		})
+	}
+	for _, list := range versions {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].ArchivedAt.After(list[j].ArchivedAt)
+		})
+	}
+	return versions, nil
+}
+
+// Returns the stored path of a version.
+func versionPath(relPath, versionID string) string {
+	return path.Join(versionsDir, relPath, versionID)
+}
+
+// Copies one file to another path within the same provider.
+func fullCopyWithin(provider storage.StorageProvider, from, to string, modTime time.Time) (int64, error) {
+	reader, err := provider.GetReader(from)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	writer, err := provider.GetWriter(to, modTime)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(writer, reader)
+	if err != nil {
+		storage.AbortWriter(writer)
+		return n, err
+	}
+	return n, writer.Close()
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"backend/internal/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates an engine holding only what version retention needs.
+func newVersionTestEngine(retention VersionRetention) *SyncEngine {
+	return &SyncEngine{
+		versionRetention: retention,
+		versionIndexes:   make(map[storage.StorageProvider]*versionIndex),
+	}
+}
+
+// Archives the current content of a file, then replaces it.
+func archiveAndReplace(t *testing.T, s *SyncEngine, provider storage.StorageProvider, root, relPath, content string) {
+	t.Helper()
+	if err := s.archiveVersion(provider, relPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, relPath), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Lists the versions of a file that are stored on disk.
+func storedVersions(t *testing.T, provider storage.StorageProvider, relPath string) []FileVersion {
+	t.Helper()
+	versions, err := listVersions(provider, versionsDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return versions[relPath]
+}
+
+func TestPruneVersionsKeepsLast(t *testing.T) {
+	root := t.TempDir()
+	provider, err := storage.NewFileSystemProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newVersionTestEngine(VersionRetention{KeepLast: 2})
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("v0"), 0o644)
+	os.WriteFile(filepath.Join(root, "b.txt"), []byte("b0"), 0o644)
+	archiveAndReplace(t, s, provider, root, "b.txt", "b1")
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		archiveAndReplace(t, s, provider, root, "a.txt", content)
+	}
+
+	versions := storedVersions(t, provider, "a.txt")
+	if len(versions) != 2 {
+		t.Fatalf("kept %d versions of a.txt, want 2", len(versions))
+	}
+	for i, want := range []string{"v3", "v2"} {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(versionPath("a.txt", versions[i].ID))))
+		if err != nil || string(data) != want {
+			t.Errorf("version %d holds %q, %v, want %q", i, data, err, want)
+		}
+	}
+	if len(storedVersions(t, provider, "b.txt")) != 1 {
+		t.Errorf("pruning a.txt touched the versions of b.txt")
+	}
+	index := s.versionIndexes[provider]
+	if len(index.versions["a.txt"]) != 2 || index.total != 6 {
+		t.Errorf("index holds %d versions of a.txt and %d bytes", len(index.versions["a.txt"]), index.total)
+	}
+}
+
+func TestPruneVersionsCapsTotalSize(t *testing.T) {
+	root := t.TempDir()
+	provider, err := storage.NewFileSystemProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newVersionTestEngine(VersionRetention{KeepLast: 10, MaxTotalBytes: 250})
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		os.WriteFile(filepath.Join(root, name), []byte(strings.Repeat("x", 100)), 0o644)
+		archiveAndReplace(t, s, provider, root, name, "new")
+	}
+
+	if len(storedVersions(t, provider, "a.txt")) != 0 {
+		t.Errorf("oldest version was not evicted")
+	}
+	if len(storedVersions(t, provider, "b.txt")) != 1 || len(storedVersions(t, provider, "c.txt")) != 1 {
+		t.Errorf("newer versions were evicted")
+	}
+	if total := s.versionIndexes[provider].total; total != 200 {
+		t.Errorf("index counts %d bytes, want 200", total)
+	}
+}
+
+func TestVersionIndexLoadsExistingVersions(t *testing.T) {
+	root := t.TempDir()
+	provider, err := storage.NewFileSystemProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("v0"), 0o644)
+	first := newVersionTestEngine(VersionRetention{KeepLast: 10})
+	for _, content := range []string{"v1", "v2", "v3"} {
+		archiveAndReplace(t, first, provider, root, "a.txt", content)
+	}
+
+	// A restarted engine picks up the versions already on disk.
+	second := newVersionTestEngine(VersionRetention{KeepLast: 1})
+	archiveAndReplace(t, second, provider, root, "a.txt", "v4")
+	if versions := storedVersions(t, provider, "a.txt"); len(versions) != 1 {
+		t.Fatalf("kept %d versions after restart, want 1", len(versions))
+	}
+}
@@ -1,10 +1,102 @@
 package models
 
-import "time"
+import (
+	"maps"
+	"os"
+	"time"
+)
 
-// Holds metadata information about a file.
+// Kinds of entries in a state map.
+const (
+	KindFile      = "file"
+	KindDirectory = "directory"
+	KindSymlink   = "symlink"
+)
+
+// Holds metadata information about a file or directory.
+// An empty Hash means the content hash is unknown and must be requested
+// from the provider before it can be compared. An empty Kind means a file;
+// directories and links have no hash and a size of zero, and links carry
+// their target instead.
 type FileMetadata struct {
 	RelativePath string
+	Kind         string
 	Hash         string
 	ModTime      time.Time
+	Size         int64
+	LinkTarget   string
+	Attrs        *FileAttributes
+}
+
+// Holds the permissions, ownership and extended attributes of a file as far
+// as its provider records them. Xattrs is nil where the filesystem does not
+// support extended attributes, and ownership is only known when Owned is set.
+type FileAttributes struct {
+	Mode       os.FileMode
+	Owned      bool
+	UID        int
+	GID        int
+	Xattrs     map[string]string
+	ChangeTime time.Time
+}
+
+// Reports whether two sets of attributes agree. Only what both sides record
+// is compared, so nil attributes match anything.
+func (a *FileAttributes) Matches(other *FileAttributes) bool {
+	if a == nil || other == nil {
+		return true
+	}
+	if a.Mode != other.Mode {
+		return false
+	}
+	if a.Owned && other.Owned && (a.UID != other.UID || a.GID != other.GID) {
+		return false
+	}
+	if a.Xattrs != nil && other.Xattrs != nil && !maps.Equal(a.Xattrs, other.Xattrs) {
+		return false
+	}
+	return true
+}
+
+// Reports whether the entry is a regular file.
+func (m FileMetadata) IsFile() bool {
+	return m.Kind == "" || m.Kind == KindFile
+}
+
+// Reports whether the entry is a directory.
+func (m FileMetadata) IsDir() bool {
+	return m.Kind == KindDirectory
+}
+
+// Reports whether the entry is a symbolic link.
+func (m FileMetadata) IsSymlink() bool {
+	return m.Kind == KindSymlink
+}
+
+// Reports whether the content hash is known.
+func (m FileMetadata) HasHash() bool {
+	return m.Hash != ""
+}
+
+// Compares two metadata entries without reading content. Directories match
+// each other regardless of mod time, and links match when their targets do.
+// The second result is false when size and mod time are not enough to decide
+// and the caller has to compare hashes.
+func (m FileMetadata) Matches(other FileMetadata) (bool, bool) {
+	if m.IsDir() || other.IsDir() {
+		return m.IsDir() == other.IsDir(), true
+	}
+	if m.IsSymlink() || other.IsSymlink() {
+		return m.IsSymlink() == other.IsSymlink() && m.LinkTarget == other.LinkTarget, true
+	}
+	if m.Size != other.Size {
+		return false, true
+	}
+	if m.HasHash() && other.HasHash() {
+		return m.Hash == other.Hash, true
+	}
+	if m.ModTime.Equal(other.ModTime) {
+		return true, true
+	}
+	return false, false
 }
@@ -0,0 +1,125 @@
+//go:build linux
+
+package storage
+
+import (
+	"backend/internal/models"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Extended attributes outside the user namespace that are synced: POSIX ACLs.
+var syncedSystemXattrs = map[string]bool{
+	"system.posix_acl_access":  true,
+	"system.posix_acl_default": true,
+}
+
+// Fills in the change time, the owner when running as root, and the extended
+// attributes of a file.
+func readPlatformAttributes(fullPath string, info os.FileInfo, attrs *models.FileAttributes) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		attrs.ChangeTime = time.Unix(stat.Ctim.Unix())
+		if os.Geteuid() == 0 {
+			attrs.Owned, attrs.UID, attrs.GID = true, int(stat.Uid), int(stat.Gid)
+		}
+	}
+	xattrs, err := readXattrs(fullPath)
+	if err != nil {
+		return err
+	}
+	attrs.Xattrs = xattrs
+	return nil
+}
+
+// Replaces the synced extended attributes of an open file with the given
+// ones. Nothing is changed when either side does not support them.
+func writePlatformAttributes(file *os.File, attrs models.FileAttributes) error {
+	if attrs.Xattrs == nil {
+		return nil
+	}
+	fd := int(file.Fd())
+	current, err := collectXattrs(file.Name(),
+		func(buf []byte) (int, error) { return unix.Flistxattr(fd, buf) },
+		func(name string, buf []byte) (int, error) { return unix.Fgetxattr(fd, name, buf) })
+	if err != nil || current == nil {
+		return err
+	}
+	for name := range current {
+		if _, keep := attrs.Xattrs[name]; !keep {
+			if err := unix.Fremovexattr(fd, name); err != nil {
+				return &os.PathError{Op: "removexattr " + name, Path: file.Name(), Err: err}
+			}
+		}
+	}
+	for name, value := range attrs.Xattrs {
+		if existing, ok := current[name]; ok && existing == value {
+			continue
+		}
+		if err := unix.Fsetxattr(fd, name, []byte(value), 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: file.Name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// Reads the synced extended attributes of a file: the user namespace and
+// POSIX ACLs. Returns nil when the filesystem does not support them.
+func readXattrs(fullPath string) (map[string]string, error) {
+	return collectXattrs(fullPath,
+		func(buf []byte) (int, error) { return syscall.Listxattr(fullPath, buf) },
+		func(name string, buf []byte) (int, error) { return syscall.Getxattr(fullPath, name, buf) })
+}
+
+// Reads the synced extended attributes of a file through list and get, which
+// fill a buffer like listxattr and getxattr do. fullPath names the file in
+// errors.
+func collectXattrs(fullPath string, list func([]byte) (int, error), get func(string, []byte) (int, error)) (map[string]string, error) {
+	size, err := list(nil)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: fullPath, Err: err}
+	}
+	xattrs := make(map[string]string)
+	if size == 0 {
+		return xattrs, nil
+	}
+	buf := make([]byte, size)
+	if size, err = list(buf); err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: fullPath, Err: err}
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if !strings.HasPrefix(name, "user.") && !syncedSystemXattrs[name] {
+			continue
+		}
+		value, err := getXattr(name, get)
+		if errors.Is(err, syscall.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr " + name, Path: fullPath, Err: err}
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+// Reads a single extended attribute through get.
+func getXattr(name string, get func(string, []byte) (int, error)) (string, error) {
+	size, err := get(name, nil)
+	if err != nil || size == 0 {
+		return "", err
+	}
+	buf := make([]byte, size)
+	size, err = get(name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
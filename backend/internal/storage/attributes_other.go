@@ -0,0 +1,19 @@
+//go:build !linux
+
+package storage
+
+import (
+	"backend/internal/models"
+	"os"
+)
+
+// Records only permissions outside Linux; ownership, change time and
+// extended attributes are left unknown.
+func readPlatformAttributes(fullPath string, info os.FileInfo, attrs *models.FileAttributes) error {
+	return nil
+}
+
+// Has nothing to apply beyond permissions outside Linux.
+func writePlatformAttributes(file *os.File, attrs models.FileAttributes) error {
+	return nil
+}
@@ -0,0 +1,578 @@
+package storage
+
+import (
+	"backend/internal/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	chunkStoreChunksDir    = "chunks"
+	chunkStoreManifestsDir = "manifests"
+	chunkManifestVersion   = 1
+)
+
+// Describes how a logical file is assembled from stored chunks.
+type chunkManifest struct {
+	Version int          `json:"version"`
+	Size    int64        `json:"size"`
+	Hash    string       `json:"hash"`
+	Chunks  []chunkEntry `json:"chunks"`
+}
+
+// References a single stored chunk.
+type chunkEntry struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Reports space usage and deduplication of a chunk store.
+type DedupStats struct {
+	Files          int     `json:"files"`
+	Chunks         int     `json:"chunks"`
+	LogicalBytes   int64   `json:"logicalBytes"`
+	StoredBytes    int64   `json:"storedBytes"`
+	DedupRatio     float64 `json:"dedupRatio"`
+	BytesWritten   int64   `json:"bytesWritten"`
+	BytesUploaded  int64   `json:"bytesUploaded"`
+	UploadSavings  float64 `json:"uploadSavings"`
+	OrphanedChunks int     `json:"orphanedChunks"`
+}
+
+// Reports the outcome of a garbage collection run.
+type GCReport struct {
+	ChunksScanned int   `json:"chunksScanned"`
+	ChunksDeleted int   `json:"chunksDeleted"`
+	BytesFreed    int64 `json:"bytesFreed"`
+}
+
+// Implemented by providers that deduplicate content and can reclaim space.
+type DedupProvider interface {
+	DedupStats() (DedupStats, error)
+	CollectGarbage() (GCReport, error)
+}
+
+// Stores files in an inner provider as content-defined chunks keyed by their
+// SHA256 hash, plus one JSON manifest per file. Writing a file only uploads
+// chunks the store does not already hold, so small edits to large files and
+// duplicate content across files cost little. Chunks that are no longer
+// referenced by any manifest are removed by CollectGarbage.
+type ChunkStoreProvider struct {
+	inner StorageProvider
+
+	// Writers hold a read lock while storing their manifest, so garbage
+	// collection never scans a half-published store.
+	gcMu sync.RWMutex
+
+	// Counts the writers using each chunk that their manifest does not
+	// reference yet; garbage collection leaves these chunks alone.
+	pendingMu sync.Mutex
+	pending   map[string]int
+
+	knownChunks sync.Map
+
+	statsMu       sync.Mutex
+	bytesWritten  int64
+	bytesUploaded int64
+}
+
+// Creates a new ChunkStoreProvider backed by inner.
+func NewChunkStoreProvider(inner StorageProvider) *ChunkStoreProvider {
+	return &ChunkStoreProvider{inner: inner, pending: make(map[string]int)}
+}
+
+// Lists logical files from their manifests and directories from the
+// manifest tree.
+func (p *ChunkStoreProvider) List() (map[string]models.FileMetadata, error) {
+	stored, err := p.inner.List()
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored)
+}
+
+// Lists the files and directories below the specified directory like List.
+func (p *ChunkStoreProvider) ListTree(relativePath string) (map[string]models.FileMetadata, error) {
+	stored, err := ListTree(p.inner, manifestPath(relativePath))
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored)
+}
+
+// Builds logical entries from the stored manifests among inner entries. An
+// unreadable manifest fails the listing rather than hiding its file.
+func (p *ChunkStoreProvider) logicalMap(stored map[string]models.FileMetadata) (map[string]models.FileMetadata, error) {
+	stateMap := make(map[string]models.FileMetadata)
+	for storedPath, meta := range stored {
+		relPath, ok := manifestRelPath(storedPath)
+		if !ok {
+			continue
+		}
+		if meta.IsDir() {
+			stateMap[relPath] = models.FileMetadata{RelativePath: relPath, Kind: meta.Kind, ModTime: meta.ModTime}
+			continue
+		}
+		manifest, err := p.readManifest(relPath)
+		if err != nil {
+			return nil, err
+		}
+		stateMap[relPath] = models.FileMetadata{
+			RelativePath: relPath,
+			Hash:         manifest.Hash,
+			ModTime:      meta.ModTime,
+			Size:         manifest.Size,
+		}
+	}
+	return stateMap, nil
+}
+
+// Returns logical size, mod time and hash from the manifest of the specified file.
+func (p *ChunkStoreProvider) Stat(relativePath string) (models.FileMetadata, error) {
+	meta, err := p.inner.Stat(manifestPath(relativePath))
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if meta.IsDir() {
+		return models.FileMetadata{RelativePath: relativePath, Kind: meta.Kind, ModTime: meta.ModTime}, nil
+	}
+	manifest, err := p.readManifest(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	return models.FileMetadata{
+		RelativePath: relativePath,
+		Hash:         manifest.Hash,
+		ModTime:      meta.ModTime,
+		Size:         manifest.Size,
+	}, nil
+}
+
+// Returns the hash recorded in the manifest of the specified file.
+func (p *ChunkStoreProvider) Hash(relativePath string) (string, error) {
+	manifest, err := p.readManifest(relativePath)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Hash, nil
+}
+
+// Builds a map of the logical state; manifests already carry hashes.
+func (p *ChunkStoreProvider) BuildStateMap() (map[string]models.FileMetadata, error) {
+	return p.List()
+}
+
+// Returns logical metadata for the specified file.
+func (p *ChunkStoreProvider) GetMetadata(relativePath string) (models.FileMetadata, error) {
+	return p.Stat(relativePath)
+}
+
+// Returns a reader that reassembles the specified file from its chunks.
+func (p *ChunkStoreProvider) GetReader(relativePath string) (io.ReadCloser, error) {
+	manifest, err := p.readManifest(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{provider: p, chunks: manifest.Chunks}, nil
+}
+
+// Returns a writer that chunks data as it arrives, uploading only chunks the
+// store is missing, and stores the manifest on Close.
+func (p *ChunkStoreProvider) GetWriter(relativePath string, modTime time.Time) (io.WriteCloser, error) {
+	return &chunkWriter{
+		provider:     p,
+		relativePath: relativePath,
+		modTime:      modTime,
+		hasher:       sha256.New(),
+	}, nil
+}
+
+// Deletes the manifest of the specified file or directory. Chunks are
+// reclaimed by CollectGarbage.
+func (p *ChunkStoreProvider) DeleteFile(relativePath string) error {
+	return p.inner.DeleteFile(manifestPath(relativePath))
+}
+
+// Ensures that the specified directory exists among the manifests.
+func (p *ChunkStoreProvider) EnsureDir(relativePath string) error {
+	return p.inner.EnsureDir(manifestPath(relativePath))
+}
+
+// Returns the root path of the inner provider.
+func (p *ChunkStoreProvider) GetPath() string {
+	return p.inner.GetPath()
+}
+
+// Maps a stored manifest path back to its logical path; chunks have none.
+func (p *ChunkStoreProvider) LogicalPath(storedPath string) (string, error) {
+	if inner, ok := p.inner.(PathMapper); ok {
+		var err error
+		if storedPath, err = inner.LogicalPath(storedPath); err != nil {
+			return "", err
+		}
+	}
+	relPath, ok := manifestRelPath(storedPath)
+	if !ok {
+		return "", fmt.Errorf("%s is not a manifest", storedPath)
+	}
+	return relPath, nil
+}
+
+// Computes deduplication statistics across all manifests and chunks.
+func (p *ChunkStoreProvider) DedupStats() (DedupStats, error) {
+	manifests, chunks, err := p.scan()
+	if err != nil {
+		return DedupStats{}, err
+	}
+
+	stats := DedupStats{Files: len(manifests), Chunks: len(chunks)}
+	referenced := make(map[string]bool)
+	for _, manifest := range manifests {
+		stats.LogicalBytes += manifest.Size
+		for _, chunk := range manifest.Chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+	for hash, size := range chunks {
+		if referenced[hash] {
+			stats.StoredBytes += size
+		} else {
+			stats.OrphanedChunks++
+		}
+	}
+	if stats.StoredBytes > 0 {
+		stats.DedupRatio = float64(stats.LogicalBytes) / float64(stats.StoredBytes)
+	}
+
+	p.statsMu.Lock()
+	stats.BytesWritten = p.bytesWritten
+	stats.BytesUploaded = p.bytesUploaded
+	p.statsMu.Unlock()
+	if stats.BytesWritten > 0 {
+		stats.UploadSavings = 1 - float64(stats.BytesUploaded)/float64(stats.BytesWritten)
+	}
+	return stats, nil
+}
+
+// Deletes chunks that are not referenced by any manifest, except those
+// in use by writers that have not stored their manifest yet.
+func (p *ChunkStoreProvider) CollectGarbage() (GCReport, error) {
+	p.gcMu.Lock()
+	defer p.gcMu.Unlock()
+
+	manifests, chunks, err := p.scan()
+	if err != nil {
+		return GCReport{}, err
+	}
+	referenced := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, chunk := range manifest.Chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+
+	report := GCReport{ChunksScanned: len(chunks)}
+	for hash, size := range chunks {
+		if referenced[hash] {
+			continue
+		}
+		deleted, err := p.deleteUnusedChunk(hash)
+		if err != nil {
+			return report, fmt.Errorf("failed to delete chunk %s: %w", hash, err)
+		}
+		if deleted {
+			report.ChunksDeleted++
+			report.BytesFreed += size
+		}
+	}
+	log.Printf("Chunk store GC removed %d of %d chunks (%d bytes)\n", report.ChunksDeleted, report.ChunksScanned, report.BytesFreed)
+	return report, nil
+}
+
+// Deletes a chunk unless a writer is using it. Holding pendingMu across the
+// deletion keeps writers from picking the chunk up meanwhile.
+func (p *ChunkStoreProvider) deleteUnusedChunk(hash string) (bool, error) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if p.pending[hash] > 0 {
+		return false, nil
+	}
+	if err := p.inner.DeleteFile(chunkPath(hash)); err != nil {
+		return false, err
+	}
+	p.knownChunks.Delete(hash)
+	return true, nil
+}
+
+// Marks a chunk as in use by a writer.
+func (p *ChunkStoreProvider) pinChunk(hash string) {
+	p.pendingMu.Lock()
+	p.pending[hash]++
+	p.pendingMu.Unlock()
+}
+
+// Releases chunks pinned by a writer.
+func (p *ChunkStoreProvider) unpinChunks(chunks []chunkEntry) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	for _, chunk := range chunks {
+		if p.pending[chunk.Hash]--; p.pending[chunk.Hash] <= 0 {
+			delete(p.pending, chunk.Hash)
+		}
+	}
+}
+
+// Reads every manifest and lists every stored chunk with its size.
+func (p *ChunkStoreProvider) scan() (map[string]chunkManifest, map[string]int64, error) {
+	stored, err := p.inner.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	manifests := make(map[string]chunkManifest)
+	chunks := make(map[string]int64)
+	for storedPath, meta := range stored {
+		if meta.IsDir() {
+			continue
+		}
+		if relPath, ok := manifestRelPath(storedPath); ok {
+			manifest, err := p.readManifest(relPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			manifests[relPath] = manifest
+			continue
+		}
+		if hash, ok := chunkHashFromPath(storedPath); ok {
+			chunks[hash] = meta.Size
+		}
+	}
+	return manifests, chunks, nil
+}
+
+// Loads and decodes the manifest of the specified file.
+func (p *ChunkStoreProvider) readManifest(relativePath string) (chunkManifest, error) {
+	reader, err := p.inner.GetReader(manifestPath(relativePath))
+	if err != nil {
+		return chunkManifest{}, err
+	}
+	defer reader.Close()
+	var manifest chunkManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return chunkManifest{}, fmt.Errorf("failed to decode manifest for %s: %w", relativePath, err)
+	}
+	if manifest.Version != chunkManifestVersion {
+		return chunkManifest{}, fmt.Errorf("unsupported manifest version %d for %s", manifest.Version, relativePath)
+	}
+	return manifest, nil
+}
+
+// Stores a chunk unless the store already holds it. Returns whether it was uploaded.
+func (p *ChunkStoreProvider) putChunk(hash string, data []byte) (bool, error) {
+	if _, ok := p.knownChunks.Load(hash); ok {
+		return false, nil
+	}
+	if _, err := p.inner.Stat(chunkPath(hash)); err == nil {
+		p.knownChunks.Store(hash, struct{}{})
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	writer, err := p.inner.GetWriter(chunkPath(hash), time.Time{})
+	if err != nil {
+		return false, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		AbortWriter(writer)
+		return false, fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+	if err := writer.Close(); err != nil {
+		return false, fmt.Errorf("failed to finalize chunk %s: %w", hash, err)
+	}
+	p.knownChunks.Store(hash, struct{}{})
+	return true, nil
+}
+
+// Returns the stored path of a file's manifest.
+func manifestPath(relativePath string) string {
+	return chunkStoreManifestsDir + "/" + strings.TrimPrefix(relativePath, "/")
+}
+
+// Returns the logical path for a stored manifest path.
+func manifestRelPath(storedPath string) (string, bool) {
+	rel, ok := strings.CutPrefix(storedPath, chunkStoreManifestsDir+"/")
+	return rel, ok && rel != ""
+}
+
+// Returns the stored path of a chunk, fanned out by hash prefix.
+func chunkPath(hash string) string {
+	return chunkStoreChunksDir + "/" + hash[:2] + "/" + hash
+}
+
+// Extracts the chunk hash from a stored chunk path.
+func chunkHashFromPath(storedPath string) (string, bool) {
+	rest, ok := strings.CutPrefix(storedPath, chunkStoreChunksDir+"/")
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 || len(parts[1]) != sha256.Size*2 {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// Chunks written data and stores the manifest on Close.
+type chunkWriter struct {
+	provider     *ChunkStoreProvider
+	relativePath string
+	modTime      time.Time
+	chunker      chunker
+	hasher       interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+	chunks []chunkEntry
+	size   int64
+	closed bool
+}
+
+// Feeds data to the chunker and stores every completed chunk.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.hasher.Write(p)
+	w.size += int64(len(p))
+	for _, chunk := range w.chunker.write(p) {
+		if err := w.store(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Hashes and stores a single chunk, pinning it until the writer finishes.
+func (w *chunkWriter) store(chunk []byte) error {
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	w.provider.pinChunk(hash)
+	uploaded, err := w.provider.putChunk(hash, chunk)
+	if err != nil {
+		w.provider.unpinChunks([]chunkEntry{{Hash: hash}})
+		return err
+	}
+	w.provider.statsMu.Lock()
+	w.provider.bytesWritten += int64(len(chunk))
+	if uploaded {
+		w.provider.bytesUploaded += int64(len(chunk))
+	}
+	w.provider.statsMu.Unlock()
+	w.chunks = append(w.chunks, chunkEntry{Hash: hash, Size: int64(len(chunk))})
+	return nil
+}
+
+// Drops the file without storing a manifest. Chunks already stored are left
+// for garbage collection.
+func (w *chunkWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.provider.unpinChunks(w.chunks)
+	return nil
+}
+
+// Stores the final chunk and the manifest.
+func (w *chunkWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer func() { w.provider.unpinChunks(w.chunks) }()
+
+	if tail := w.chunker.flush(); len(tail) > 0 {
+		if err := w.store(tail); err != nil {
+			return err
+		}
+	}
+
+	manifest := chunkManifest{
+		Version: chunkManifestVersion,
+		Size:    w.size,
+		Hash:    hex.EncodeToString(w.hasher.Sum(nil)),
+		Chunks:  w.chunks,
+	}
+	if manifest.Chunks == nil {
+		manifest.Chunks = []chunkEntry{}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest for %s: %w", w.relativePath, err)
+	}
+	w.provider.gcMu.RLock()
+	defer w.provider.gcMu.RUnlock()
+	writer, err := w.provider.inner.GetWriter(manifestPath(w.relativePath), w.modTime)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		AbortWriter(writer)
+		return fmt.Errorf("failed to write manifest for %s: %w", w.relativePath, err)
+	}
+	return writer.Close()
+}
+
+// Streams a file by reading its chunks in order, verifying each one.
+type chunkReader struct {
+	provider *ChunkStoreProvider
+	chunks   []chunkEntry
+	buf      []byte
+}
+
+// Reads reassembled file content.
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Loads and verifies the next chunk.
+func (r *chunkReader) next() error {
+	entry := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	reader, err := r.provider.inner.GetReader(chunkPath(entry.Hash))
+	if err != nil {
+		return fmt.Errorf("missing chunk %s: %w", entry.Hash, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %s: %w", entry.Hash, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != entry.Hash {
+		return fmt.Errorf("chunk %s is corrupt", entry.Hash)
+	}
+	r.buf = data
+	return nil
+}
+
+// Releases nothing; chunks are closed as they are consumed.
+func (r *chunkReader) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// Creates a ChunkStoreProvider over a fresh directory.
+func newTestChunkStore(t *testing.T) *ChunkStoreProvider {
+	t.Helper()
+	inner, err := NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewChunkStoreProvider(inner)
+}
+
+func TestChunkStoreRoundTripAndDedup(t *testing.T) {
+	provider := newTestChunkStore(t)
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+	writeTestFile(t, provider, "a.bin", data)
+	writeTestFile(t, provider, "b.bin", data)
+
+	for _, relPath := range []string{"a.bin", "b.bin"} {
+		got, err := readTestFile(provider, relPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: round trip returned %d different bytes", relPath, len(got))
+		}
+	}
+	stats, err := provider.DedupStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LogicalBytes != 2*int64(len(data)) || stats.StoredBytes != int64(len(data)) {
+		t.Errorf("stats report %d logical and %d stored bytes", stats.LogicalBytes, stats.StoredBytes)
+	}
+}
+
+func TestChunkStoreGCKeepsChunksOfOpenWriters(t *testing.T) {
+	provider := newTestChunkStore(t)
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+
+	// The writer is left open while its chunks are unreferenced.
+	writer, err := provider.GetWriter("pending.bin", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, provider, "orphan.bin", []byte("soon unreferenced"))
+	if err := provider.DeleteFile("orphan.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan GCReport)
+	go func() {
+		report, err := provider.CollectGarbage()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- report
+	}()
+	select {
+	case report := <-done:
+		if report.ChunksDeleted != 1 {
+			t.Errorf("GC deleted %d chunks, want only the orphan", report.ChunksDeleted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC blocked behind an open writer")
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readTestFile(provider, "pending.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("file written across GC was corrupted")
+	}
+	if report, err := provider.CollectGarbage(); err != nil || report.ChunksDeleted != 0 {
+		t.Fatalf("second GC deleted %d chunks, %v", report.ChunksDeleted, err)
+	}
+}
+
+func TestChunkStoreGCAfterAbort(t *testing.T) {
+	provider := newTestChunkStore(t)
+	data := make([]byte, 512*1024)
+	rand.Read(data)
+	writer, err := provider.GetWriter("aborted.bin", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := AbortWriter(writer); err != nil {
+		t.Fatal(err)
+	}
+	report, err := provider.CollectGarbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ChunksDeleted == 0 || report.ChunksDeleted != report.ChunksScanned {
+		t.Fatalf("GC deleted %d of %d chunks of an aborted writer", report.ChunksDeleted, report.ChunksScanned)
+	}
+}
+
+func TestChunkStoreListFailsOnCorruptManifest(t *testing.T) {
+	provider := newTestChunkStore(t)
+	writeTestFile(t, provider, "dir/good.bin", []byte("intact"))
+	writeTestFile(t, provider, "dir/bad.bin", []byte("about to lose its manifest"))
+	if listed, err := provider.ListTree("dir"); err != nil || len(listed) != 2 {
+		t.Fatalf("ListTree returned %d entries: %v", len(listed), err)
+	}
+	writeTestFile(t, provider.inner, manifestPath("dir/bad.bin"), []byte("not a manifest"))
+
+	if _, err := provider.List(); err == nil {
+		t.Error("List hid a corrupt manifest")
+	}
+	if _, err := provider.ListTree("dir"); err == nil {
+		t.Error("ListTree hid a corrupt manifest")
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+const (
+	minChunkSize = 16 * 1024
+	avgChunkSize = 64 * 1024
+	maxChunkSize = 256 * 1024
+
+	// Boundaries are harder to hit before the average size and easier after,
+	// which narrows the chunk size distribution (normalized chunking).
+	chunkMaskSmall uint64 = 0x0003590703530000 // 15 bits
+	chunkMaskLarge uint64 = 0x0000d90003530000 // 11 bits
+)
+
+// Random values used by the gear rolling hash, generated deterministically so
+// boundaries are stable across runs.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Splits a byte stream into content-defined chunks using a gear rolling hash.
+// Inserting or removing bytes only changes the chunks around the edit.
+type chunker struct {
+	buf  []byte
+	hash uint64
+}
+
+// Appends data and returns every chunk completed by it.
+func (c *chunker) write(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n, cut := c.scan(data)
+		c.buf = append(c.buf, data[:n]...)
+		data = data[n:]
+		if cut {
+			chunks = append(chunks, c.buf)
+			c.buf = make([]byte, 0, avgChunkSize)
+			c.hash = 0
+		}
+	}
+	return chunks
+}
+
+// Returns the pending data as the final chunk.
+func (c *chunker) flush() []byte {
+	chunk := c.buf
+	c.buf = nil
+	c.hash = 0
+	return chunk
+}
+
+// Consumes bytes from data until a boundary is found or data runs out.
+func (c *chunker) scan(data []byte) (int, bool) {
+	size := len(c.buf)
+	for i, b := range data {
+		size++
+		if size < minChunkSize {
+			continue
+		}
+		c.hash = (c.hash << 1) + gearTable[b]
+		mask := chunkMaskSmall
+		if size >= avgChunkSize {
+			mask = chunkMaskLarge
+		}
+		if c.hash&mask == 0 || size >= maxChunkSize {
+			return i + 1, true
+		}
+	}
+	return len(data), false
+}
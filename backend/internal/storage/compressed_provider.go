@@ -0,0 +1,608 @@
+package storage
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	compressionMagic      = "FSZ1"
+	compressionVersion    = 1
+	compressionHeaderSize = len(compressionMagic) + 2 + 8 + sha256.Size + crc32.Size
+	compressionSniffSize  = 512
+	compressionIndexFile  = ".sync-compression-index"
+
+	compressionModeStored byte = 0
+	compressionModeGzip   byte = 1
+)
+
+// Extensions of formats that are already compressed and gain nothing from gzip.
+var DefaultIncompressibleExtensions = []string{
+	".gz", ".tgz", ".zip", ".zst", ".xz", ".bz2", ".7z", ".rar",
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic",
+	".mp3", ".aac", ".ogg", ".flac", ".mp4", ".mkv", ".mov", ".avi", ".webm",
+	".docx", ".xlsx", ".pptx", ".odt", ".jar", ".apk",
+}
+
+// Magic bytes of already compressed formats, checked when the extension is not conclusive.
+var incompressibleMagic = [][]byte{
+	{0x1f, 0x8b},                       // gzip
+	{'P', 'K', 0x03, 0x04},             // zip and derived formats
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'B', 'Z', 'h'},                    // bzip2
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	{'R', 'a', 'r', '!'},               // rar
+	{0x89, 'P', 'N', 'G'},              // png
+	{0xff, 0xd8, 0xff},                 // jpeg
+	{'G', 'I', 'F', '8'},               // gif
+	{'I', 'D', '3'},                    // mp3
+	{0x1a, 0x45, 0xdf, 0xa3},           // matroska/webm
+}
+
+// Configures a CompressedProvider.
+type CompressionConfig struct {
+	Level                    int
+	IncompressibleExtensions []string
+}
+
+// Wraps a StorageProvider, gzip-compressing file contents on write and
+// decompressing them on read.
+//
+// Each stored file starts with a checksummed header holding the format
+// version, the storage mode and the uncompressed size and SHA256 hash, so
+// Stat, Hash and BuildStateMap report logical values without decompressing.
+// Files written without the wrapper are passed through unchanged.
+//
+// The logical size and hash of every file are also kept in an index next to
+// the stored files, so List does not have to read the headers. An index entry
+// is trusted only while the stored size and mod time it was recorded with
+// still match.
+type CompressedProvider struct {
+	inner      StorageProvider
+	level      int
+	extensions map[string]bool
+
+	indexMu     sync.Mutex
+	index       map[string]compressionIndexEntry
+	indexLoaded bool
+	indexDirty  bool
+}
+
+// Records the logical metadata of a stored file at a given stored size and
+// mod time. Plain marks files without a header.
+type compressionIndexEntry struct {
+	StoredSize int64     `json:"storedSize"`
+	ModTime    time.Time `json:"modTime"`
+	Size       int64     `json:"size"`
+	Hash       string    `json:"hash,omitempty"`
+	Plain      bool      `json:"plain,omitempty"`
+}
+
+// Creates a new CompressedProvider around inner.
+func NewCompressedProvider(inner StorageProvider, cfg CompressionConfig) (*CompressedProvider, error) {
+	if cfg.Level < gzip.HuffmanOnly || cfg.Level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d", cfg.Level)
+	}
+	exts := cfg.IncompressibleExtensions
+	if exts == nil {
+		exts = DefaultIncompressibleExtensions
+	}
+	extensions := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		extensions[strings.ToLower(ext)] = true
+	}
+	return &CompressedProvider{inner: inner, level: cfg.Level, extensions: extensions}, nil
+}
+
+// Lists files with their uncompressed sizes and hashes, and directories.
+// Logical metadata comes from the index; only files the index does not know
+// at their current stored size and mod time, such as files written by another
+// process, have their header read.
+func (p *CompressedProvider) List() (map[string]models.FileMetadata, error) {
+	stored, err := p.inner.List()
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored, "")
+}
+
+// Lists the entries below the specified directory like List.
+func (p *CompressedProvider) ListTree(relativePath string) (map[string]models.FileMetadata, error) {
+	stored, err := ListTree(p.inner, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored, strings.TrimSuffix(relativePath, "/")+"/")
+}
+
+// Converts inner entries listed below prefix, or the whole root when prefix
+// is empty, into logical entries, dropping index entries of files that are
+// gone.
+func (p *CompressedProvider) logicalMap(stored map[string]models.FileMetadata, prefix string) (map[string]models.FileMetadata, error) {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	p.loadIndexLocked()
+
+	stateMap := make(map[string]models.FileMetadata, len(stored))
+	for relPath, meta := range stored {
+		if relPath == compressionIndexFile {
+			continue
+		}
+		if !meta.IsFile() {
+			stateMap[relPath] = meta
+			continue
+		}
+		entry, ok := p.index[relPath]
+		if !ok || entry.StoredSize != meta.Size || !entry.ModTime.Equal(meta.ModTime) {
+			header, isHeader, err := p.readHeader(relPath)
+			if err != nil {
+				return nil, err
+			}
+			entry = p.recordLocked(meta, header, isHeader)
+		}
+		stateMap[relPath] = entry.logical(meta)
+	}
+	for relPath := range p.index {
+		if _, ok := stored[relPath]; !ok && strings.HasPrefix(relPath, prefix) {
+			delete(p.index, relPath)
+			p.indexDirty = true
+		}
+	}
+	p.saveIndexLocked()
+	return stateMap, nil
+}
+
+// Returns the uncompressed size and mod time of the specified file.
+// Compressed files also report their logical hash from the header.
+func (p *CompressedProvider) Stat(relativePath string) (models.FileMetadata, error) {
+	meta, err := p.inner.Stat(relativePath)
+	if err != nil || meta.IsDir() {
+		return meta, err
+	}
+	header, ok, err := p.readHeader(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	p.indexMu.Lock()
+	entry := p.recordLocked(meta, header, ok)
+	p.indexMu.Unlock()
+	return entry.logical(meta), nil
+}
+
+// Returns the hash of the uncompressed content.
+func (p *CompressedProvider) Hash(relativePath string) (string, error) {
+	header, ok, err := p.readHeader(relativePath)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return header.hash, nil
+	}
+	return p.inner.Hash(relativePath)
+}
+
+// Builds a map of the logical state of the inner provider.
+func (p *CompressedProvider) BuildStateMap() (map[string]models.FileMetadata, error) {
+	stateMap, err := p.List()
+	if err != nil {
+		return nil, err
+	}
+	for relPath, meta := range stateMap {
+		if meta.HasHash() || meta.IsDir() {
+			continue
+		}
+		if meta.Hash, err = p.Hash(relPath); err != nil {
+			return nil, err
+		}
+		stateMap[relPath] = meta
+	}
+	return stateMap, nil
+}
+
+// Returns logical metadata for the specified file.
+func (p *CompressedProvider) GetMetadata(relativePath string) (models.FileMetadata, error) {
+	meta, err := p.Stat(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if !meta.HasHash() && !meta.IsDir() {
+		if meta.Hash, err = p.Hash(relativePath); err != nil {
+			return models.FileMetadata{}, err
+		}
+	}
+	return meta, nil
+}
+
+// Applies attributes to the stored file, which keeps the logical name, when
+// the inner provider supports them.
+func (p *CompressedProvider) SetAttributes(relativePath string, attrs models.FileAttributes) error {
+	if setter, ok := p.inner.(AttributeSetter); ok {
+		return setter.SetAttributes(relativePath, attrs)
+	}
+	return nil
+}
+
+// Returns a reader that transparently decompresses the specified file.
+func (p *CompressedProvider) GetReader(relativePath string) (io.ReadCloser, error) {
+	reader, err := p.inner.GetReader(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReaderSize(reader, compressionHeaderSize)
+	header, ok, err := parseCompressionHeader(buffered)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s: %w", relativePath, err)
+	}
+	if !ok || header.mode == compressionModeStored {
+		return &readCloser{Reader: buffered, Closer: reader}, nil
+	}
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("failed to open compressed stream %s: %w", relativePath, err)
+	}
+	return &readCloser{Reader: gz, Closer: reader}, nil
+}
+
+// Returns a writer that compresses into the specified file on Close.
+// Output is staged in a temporary file because the header needs the
+// content hash before the body can be written.
+func (p *CompressedProvider) GetWriter(relativePath string, modTime time.Time) (io.WriteCloser, error) {
+	staging, err := os.CreateTemp("", "file-sync-compress-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file for %s: %w", relativePath, err)
+	}
+	return &compressingWriter{
+		provider:     p,
+		relativePath: relativePath,
+		modTime:      modTime,
+		staging:      staging,
+		hasher:       sha256.New(),
+		skip:         p.extensions[strings.ToLower(path.Ext(relativePath))],
+	}, nil
+}
+
+// Deletes the specified file from the inner provider.
+func (p *CompressedProvider) DeleteFile(relativePath string) error {
+	if err := p.inner.DeleteFile(relativePath); err != nil {
+		return err
+	}
+	p.indexMu.Lock()
+	if _, ok := p.index[relativePath]; ok {
+		delete(p.index, relativePath)
+		p.indexDirty = true
+	}
+	p.indexMu.Unlock()
+	return nil
+}
+
+// Ensures that the specified directory exists in the inner provider.
+func (p *CompressedProvider) EnsureDir(relativePath string) error {
+	return p.inner.EnsureDir(relativePath)
+}
+
+// Returns the root path of the inner provider.
+func (p *CompressedProvider) GetPath() string {
+	return p.inner.GetPath()
+}
+
+// Forwards path mapping to the inner provider.
+func (p *CompressedProvider) LogicalPath(storedPath string) (string, error) {
+	if inner, ok := p.inner.(PathMapper); ok {
+		return inner.LogicalPath(storedPath)
+	}
+	return storedPath, nil
+}
+
+// Returns the logical metadata of a stored file described by the entry.
+func (e compressionIndexEntry) logical(stored models.FileMetadata) models.FileMetadata {
+	if e.Plain {
+		return stored
+	}
+	stored.Size = e.Size
+	stored.Hash = e.Hash
+	return stored
+}
+
+// Records what a header says about a stored file in the index and returns
+// the entry. The caller holds indexMu.
+func (p *CompressedProvider) recordLocked(stored models.FileMetadata, header compressionHeader, isHeader bool) compressionIndexEntry {
+	entry := compressionIndexEntry{StoredSize: stored.Size, ModTime: stored.ModTime, Plain: !isHeader}
+	if isHeader {
+		entry.Size = header.size
+		entry.Hash = header.hash
+	}
+	if p.index == nil {
+		p.index = make(map[string]compressionIndexEntry)
+	}
+	if old, ok := p.index[stored.RelativePath]; !ok || old != entry {
+		p.index[stored.RelativePath] = entry
+		p.indexDirty = true
+	}
+	return entry
+}
+
+// Records the header of a file just written, so the next List does not have
+// to read it back.
+func (p *CompressedProvider) remember(relativePath string, header compressionHeader) {
+	stored, err := p.inner.Stat(relativePath)
+	if err != nil {
+		return
+	}
+	p.indexMu.Lock()
+	p.recordLocked(stored, header, true)
+	p.indexMu.Unlock()
+}
+
+// Reads the index from the inner provider on first use. A missing or
+// unreadable index starts out empty and is rebuilt from the headers.
+func (p *CompressedProvider) loadIndexLocked() {
+	if p.indexLoaded {
+		return
+	}
+	p.indexLoaded = true
+	if p.index == nil {
+		p.index = make(map[string]compressionIndexEntry)
+	}
+	reader, err := p.inner.GetReader(compressionIndexFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Rebuilding compression index: %v\n", err)
+		}
+		return
+	}
+	defer reader.Close()
+	var index map[string]compressionIndexEntry
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		log.Printf("Rebuilding unreadable compression index: %v\n", err)
+		return
+	}
+	for relPath, entry := range index {
+		if _, ok := p.index[relPath]; !ok {
+			p.index[relPath] = entry
+		}
+	}
+}
+
+// Stores the index in the inner provider if it changed. Failures are only
+// logged, since the index can always be rebuilt from the headers.
+func (p *CompressedProvider) saveIndexLocked() {
+	if !p.indexDirty {
+		return
+	}
+	data, err := json.Marshal(p.index)
+	if err != nil {
+		log.Printf("Failed to encode compression index: %v\n", err)
+		return
+	}
+	writer, err := p.inner.GetWriter(compressionIndexFile, time.Time{})
+	if err != nil {
+		log.Printf("Failed to store compression index: %v\n", err)
+		return
+	}
+	if _, err := writer.Write(data); err != nil {
+		AbortWriter(writer)
+		log.Printf("Failed to store compression index: %v\n", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("Failed to store compression index: %v\n", err)
+		return
+	}
+	p.indexDirty = false
+}
+
+// Describes the header of a file written by the wrapper.
+type compressionHeader struct {
+	mode byte
+	size int64
+	hash string
+}
+
+// Reads the header of the specified file. The boolean is false for files
+// that were not written by the wrapper.
+func (p *CompressedProvider) readHeader(relativePath string) (compressionHeader, bool, error) {
+	reader, err := p.inner.GetReader(relativePath)
+	if err != nil {
+		return compressionHeader{}, false, err
+	}
+	defer reader.Close()
+	header, ok, err := parseCompressionHeader(bufio.NewReaderSize(reader, compressionHeaderSize))
+	if err != nil {
+		return compressionHeader{}, false, fmt.Errorf("%s: %w", relativePath, err)
+	}
+	return header, ok, nil
+}
+
+// Consumes the header from a stream if present, leaving other content unread.
+// Content that merely starts with the magic fails the checksum and is left
+// alone as well.
+func parseCompressionHeader(reader *bufio.Reader) (compressionHeader, bool, error) {
+	peek, err := reader.Peek(compressionHeaderSize)
+	if err != nil && err != io.EOF {
+		return compressionHeader{}, false, err
+	}
+	if len(peek) < compressionHeaderSize || string(peek[:len(compressionMagic)]) != compressionMagic {
+		return compressionHeader{}, false, nil
+	}
+	body := peek[:compressionHeaderSize-crc32.Size]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(peek[len(body):]) {
+		return compressionHeader{}, false, nil
+	}
+	if version := peek[len(compressionMagic)]; version != compressionVersion {
+		return compressionHeader{}, false, fmt.Errorf("unsupported compression format version %d", version)
+	}
+	header := compressionHeader{
+		mode: peek[len(compressionMagic)+1],
+		size: int64(binary.BigEndian.Uint64(peek[len(compressionMagic)+2:])),
+		hash: hex.EncodeToString(peek[len(compressionMagic)+10 : len(body)]),
+	}
+	if header.mode != compressionModeStored && header.mode != compressionModeGzip {
+		return compressionHeader{}, false, fmt.Errorf("unknown compression mode %d", header.mode)
+	}
+	if _, err := reader.Discard(compressionHeaderSize); err != nil {
+		return compressionHeader{}, false, err
+	}
+	return header, true, nil
+}
+
+// Reports whether content starting with head is already compressed.
+func isCompressedContent(head []byte) bool {
+	for _, magic := range incompressibleMagic {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP"
+}
+
+// Combines a reader with the closer of the stream it wraps.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Compresses written data into a staging file and uploads it on Close.
+type compressingWriter struct {
+	provider     *CompressedProvider
+	relativePath string
+	modTime      time.Time
+	staging      *os.File
+	hasher       interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+	skip  bool
+	head  []byte
+	body  io.WriteCloser
+	size  int64
+	mode  byte
+	attrs *models.FileAttributes
+}
+
+// Buffers the first bytes to sniff the format, then streams into the staging file.
+func (w *compressingWriter) Write(p []byte) (int, error) {
+	w.hasher.Write(p)
+	w.size += int64(len(p))
+	if w.body != nil {
+		return w.body.Write(p)
+	}
+	w.head = append(w.head, p...)
+	if len(w.head) < compressionSniffSize {
+		return len(p), nil
+	}
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Chooses the storage mode and flushes the sniffed prefix.
+func (w *compressingWriter) start() error {
+	w.mode = compressionModeGzip
+	if w.skip || isCompressedContent(w.head) {
+		w.mode = compressionModeStored
+	}
+	if w.mode == compressionModeGzip {
+		gz, err := gzip.NewWriterLevel(w.staging, w.provider.level)
+		if err != nil {
+			return err
+		}
+		w.body = gz
+	} else {
+		w.body = nopWriteCloser{w.staging}
+	}
+	_, err := w.body.Write(w.head)
+	w.head = nil
+	return err
+}
+
+// Records attributes to hand to the inner provider's writer on Close.
+func (w *compressingWriter) SetAttributes(attrs models.FileAttributes) {
+	w.attrs = &attrs
+}
+
+// Discards the staged body without writing to the inner provider.
+func (w *compressingWriter) Abort() error {
+	w.staging.Close()
+	return os.Remove(w.staging.Name())
+}
+
+// Writes the header and staged body to the inner provider.
+func (w *compressingWriter) Close() error {
+	defer os.Remove(w.staging.Name())
+	defer w.staging.Close()
+
+	if w.body == nil {
+		if err := w.start(); err != nil {
+			return fmt.Errorf("failed to compress %s: %w", w.relativePath, err)
+		}
+	}
+	if err := w.body.Close(); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", w.relativePath, err)
+	}
+	if _, err := w.staging.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind staging file: %w", err)
+	}
+
+	header := append([]byte(compressionMagic), compressionVersion, w.mode)
+	header = binary.BigEndian.AppendUint64(header, uint64(w.size))
+	header = w.hasher.Sum(header)
+	header = binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(header))
+
+	dst, err := w.provider.inner.GetWriter(w.relativePath, w.modTime)
+	if err != nil {
+		return err
+	}
+	attrsPending := w.attrs != nil && !SetWriterAttributes(dst, *w.attrs)
+	if _, err := dst.Write(header); err != nil {
+		AbortWriter(dst)
+		return fmt.Errorf("failed to write header for %s: %w", w.relativePath, err)
+	}
+	if _, err := io.Copy(dst, w.staging); err != nil {
+		AbortWriter(dst)
+		return fmt.Errorf("failed to write %s: %w", w.relativePath, err)
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if attrsPending {
+		if err := w.provider.SetAttributes(w.relativePath, *w.attrs); err != nil {
+			return err
+		}
+	}
+	w.provider.remember(w.relativePath, compressionHeader{
+		mode: w.mode,
+		size: w.size,
+		hash: hex.EncodeToString(header[len(compressionMagic)+10 : len(header)-crc32.Size]),
+	})
+	return nil
+}
+
+// Adapts a writer whose Close must not close the underlying file.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Does nothing; the staging file is closed by its owner.
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Counts the readers opened on the wrapped provider.
+type countingProvider struct {
+	StorageProvider
+	readers int
+}
+
+func (p *countingProvider) GetReader(relativePath string) (io.ReadCloser, error) {
+	p.readers++
+	return p.StorageProvider.GetReader(relativePath)
+}
+
+// Creates a CompressedProvider over a fresh directory, returning both.
+func newTestCompressedProvider(t *testing.T) (*CompressedProvider, *FileSystemProvider) {
+	t.Helper()
+	inner, err := NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewCompressedProvider(inner, CompressionConfig{Level: gzip.DefaultCompression})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, inner
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	provider, inner := newTestCompressedProvider(t)
+	random := make([]byte, 100000)
+	rand.Read(random)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(strings.Repeat("already compressed ", 100)))
+	gz.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		data     []byte
+		wantMode byte
+	}{
+		{"empty", "empty.txt", nil, compressionModeGzip},
+		{"short text", "short.txt", []byte("hi"), compressionModeGzip},
+		{"repetitive text", "long.txt", bytes.Repeat([]byte("all work and no play "), 10000), compressionModeGzip},
+		{"random data", "random.bin", random, compressionModeGzip},
+		{"skipped extension", "photo.PNG", []byte(strings.Repeat("not really a png ", 100)), compressionModeStored},
+		{"sniffed gzip", "archive.dat", gzipped.Bytes(), compressionModeStored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTestFile(t, provider, tt.path, tt.data)
+
+			got, err := readTestFile(provider, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("round trip returned %d bytes, want %d", len(got), len(tt.data))
+			}
+			header, ok, err := provider.readHeader(tt.path)
+			if err != nil || !ok {
+				t.Fatalf("readHeader returned %v, %v", ok, err)
+			}
+			if header.mode != tt.wantMode {
+				t.Errorf("stored with mode %d, want %d", header.mode, tt.wantMode)
+			}
+			meta, err := provider.GetMetadata(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Size != int64(len(tt.data)) || meta.Hash != hashBytes(tt.data) {
+				t.Errorf("metadata reports size %d hash %s", meta.Size, meta.Hash)
+			}
+		})
+	}
+
+	stored, err := os.ReadFile(filepath.Join(inner.GetPath(), "long.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) > 10000 {
+		t.Errorf("repetitive text stored in %d bytes", len(stored))
+	}
+}
+
+func TestCompressedPassesThroughPlainFiles(t *testing.T) {
+	provider, inner := newTestCompressedProvider(t)
+	tests := map[string][]byte{
+		"plain.txt":    []byte("written without the wrapper"),
+		"lookalike.md": append([]byte(compressionMagic), bytes.Repeat([]byte{compressionVersion, compressionModeGzip, 0}, 40)...),
+		"magic.txt":    []byte(compressionMagic),
+	}
+	for relPath, data := range tests {
+		if err := os.WriteFile(filepath.Join(inner.GetPath(), relPath), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readTestFile(provider, relPath)
+		if err != nil {
+			t.Fatalf("%s: %v", relPath, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: read %q, want %q", relPath, got, data)
+		}
+		meta, err := provider.GetMetadata(relPath)
+		if err != nil {
+			t.Fatalf("%s: %v", relPath, err)
+		}
+		if meta.Size != int64(len(data)) || meta.Hash != hashBytes(data) {
+			t.Errorf("%s: metadata reports size %d hash %s", relPath, meta.Size, meta.Hash)
+		}
+	}
+}
+
+func TestCompressedListUsesIndex(t *testing.T) {
+	dir := t.TempDir()
+	inner, err := NewFileSystemProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingProvider{StorageProvider: inner}
+	provider, err := NewCompressedProvider(counting, CompressionConfig{Level: gzip.DefaultCompression})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("indexed "), 1000)
+	for _, relPath := range []string{"a.txt", "b.txt", "dir/c.txt"} {
+		writeTestFile(t, provider, relPath, data)
+	}
+
+	counting.readers = 0
+	listed, err := provider.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the missing index itself is looked for.
+	if counting.readers != 1 {
+		t.Errorf("List opened %d readers", counting.readers)
+	}
+	if meta := listed["dir/c.txt"]; meta.Size != int64(len(data)) || meta.Hash != hashBytes(data) {
+		t.Errorf("List reported %+v", meta)
+	}
+	if _, ok := listed[compressionIndexFile]; ok {
+		t.Errorf("List reported the index")
+	}
+
+	// A fresh provider loads the stored index and reads only the header of
+	// a file changed behind its back.
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("replaced"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	counting.readers = 0
+	reopened, err := NewCompressedProvider(counting, CompressionConfig{Level: gzip.DefaultCompression})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err = reopened.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counting.readers != 2 {
+		t.Errorf("List of reopened provider opened %d readers", counting.readers)
+	}
+	if meta := listed["b.txt"]; meta.Size != int64(len("replaced")) || meta.HasHash() {
+		t.Errorf("List reported changed file as %+v", meta)
+	}
+	if meta := listed["a.txt"]; meta.Size != int64(len(data)) {
+		t.Errorf("List reported %+v", meta)
+	}
+}
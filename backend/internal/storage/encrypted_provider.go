@@ -0,0 +1,658 @@
+package storage
+
+import (
+	"backend/internal/models"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	encryptionMagic      = "FSE1"
+	encryptionSaltFile   = ".sync-encryption-salt"
+	encryptionKeySize    = 32
+	encryptionSaltSize   = 32
+	encryptionChunkSize  = 64 * 1024
+	encryptionTagSize    = 16
+	encryptionMetaSize   = 8 + sha256.Size + encryptionTagSize
+	encryptionHeaderSize = len(encryptionMagic) + encryptionSaltSize + encryptionMetaSize
+	passphraseIterations = 600000
+)
+
+// Returned when stored content cannot be authenticated with the configured key.
+var ErrDecryption = errors.New("decryption failed")
+
+// Lowercase base32 keeps encrypted names valid on case-insensitive filesystems.
+var nameEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Configures an EncryptedProvider. Exactly one of Passphrase or KeyFile is required.
+type EncryptionConfig struct {
+	Passphrase   string
+	KeyFile      string
+	EncryptNames bool
+}
+
+// Wraps a StorageProvider, encrypting file contents and optionally file names
+// before they reach the inner provider.
+//
+// Each file is stored as a header followed by AES-256-GCM sealed chunks. The
+// header carries a random per-file salt from which the content key is derived,
+// plus the plaintext size and SHA256 hash sealed under that key, so Stat and
+// Hash can answer without decrypting the whole file.
+type EncryptedProvider struct {
+	inner        StorageProvider
+	masterKey    []byte
+	nameAEAD     cipher.AEAD
+	nameIVKey    []byte
+	encryptNames bool
+}
+
+// Creates a new EncryptedProvider around inner using the configured key source.
+func NewEncryptedProvider(inner StorageProvider, cfg EncryptionConfig) (*EncryptedProvider, error) {
+	var key []byte
+	var err error
+	switch {
+	case cfg.KeyFile != "" && cfg.Passphrase != "":
+		return nil, fmt.Errorf("encryption key file and passphrase are mutually exclusive")
+	case cfg.KeyFile != "":
+		key, err = LoadKeyFile(cfg.KeyFile)
+	case cfg.Passphrase != "":
+		var salt []byte
+		salt, err = loadOrCreateSalt(inner)
+		if err == nil {
+			key, err = KeyFromPassphrase(cfg.Passphrase, salt)
+		}
+	default:
+		return nil, fmt.Errorf("encryption requires a passphrase or key file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nameKey, err := hkdf.Key(sha256.New, key, nil, "file-sync names", encryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive name key: %w", err)
+	}
+	nameIVKey, err := hkdf.Key(sha256.New, key, nil, "file-sync name iv", encryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive name iv key: %w", err)
+	}
+	nameAEAD, err := newGCM(nameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedProvider{
+		inner:        inner,
+		masterKey:    key,
+		nameAEAD:     nameAEAD,
+		nameIVKey:    nameIVKey,
+		encryptNames: cfg.EncryptNames,
+	}, nil
+}
+
+// Derives a 256-bit key from a passphrase with PBKDF2-SHA256.
+func KeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, passphraseIterations, encryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	return key, nil
+}
+
+// Loads a 256-bit key from a file holding either 32 raw bytes or 64 hex characters.
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+	if len(data) == encryptionKeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("key file %s must contain %d raw bytes or %d hex characters", path, encryptionKeySize, encryptionKeySize*2)
+	}
+	return key, nil
+}
+
+// Reads the passphrase salt stored in the inner provider, creating it on first use.
+func loadOrCreateSalt(inner StorageProvider) ([]byte, error) {
+	reader, err := inner.GetReader(encryptionSaltFile)
+	if err == nil {
+		defer reader.Close()
+		salt := make([]byte, encryptionSaltSize)
+		if _, err := io.ReadFull(reader, salt); err != nil {
+			return nil, fmt.Errorf("failed to read encryption salt: %w", err)
+		}
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open encryption salt: %w", err)
+	}
+
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption salt: %w", err)
+	}
+	writer, err := inner.GetWriter(encryptionSaltFile, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encryption salt: %w", err)
+	}
+	if _, err := writer.Write(salt); err != nil {
+		AbortWriter(writer)
+		return nil, fmt.Errorf("failed to write encryption salt: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize encryption salt: %w", err)
+	}
+	return salt, nil
+}
+
+// Lists files and directories in the inner provider, reporting plaintext
+// names and sizes.
+func (p *EncryptedProvider) List() (map[string]models.FileMetadata, error) {
+	stored, err := p.inner.List()
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored), nil
+}
+
+// Lists the entries below the specified directory like List.
+func (p *EncryptedProvider) ListTree(relativePath string) (map[string]models.FileMetadata, error) {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := ListTree(p.inner, storedPath)
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored), nil
+}
+
+// Rekeys a map of stored entries by plaintext path with plaintext sizes.
+func (p *EncryptedProvider) logicalMap(stored map[string]models.FileMetadata) map[string]models.FileMetadata {
+	stateMap := make(map[string]models.FileMetadata, len(stored))
+	for storedPath, meta := range stored {
+		if meta, ok := p.logicalMetadata(storedPath, meta); ok {
+			stateMap[meta.RelativePath] = meta
+		}
+	}
+	return stateMap
+}
+
+// Returns plaintext size and mod time for the specified file.
+func (p *EncryptedProvider) Stat(relativePath string) (models.FileMetadata, error) {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	meta, err := p.inner.Stat(storedPath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if meta.IsDir() {
+		return models.FileMetadata{RelativePath: relativePath, Kind: meta.Kind, ModTime: meta.ModTime}, nil
+	}
+	size, ok := plaintextSize(meta.Size)
+	if !ok {
+		return models.FileMetadata{}, fmt.Errorf("%w: %s has an invalid size", ErrDecryption, relativePath)
+	}
+	return models.FileMetadata{RelativePath: relativePath, ModTime: meta.ModTime, Size: size}, nil
+}
+
+// Returns the plaintext hash recorded in the file header.
+func (p *EncryptedProvider) Hash(relativePath string) (string, error) {
+	header, err := p.readHeader(relativePath)
+	if err != nil {
+		return "", err
+	}
+	return header.hash, nil
+}
+
+// Builds a map of plaintext metadata, reading only the header of each file.
+func (p *EncryptedProvider) BuildStateMap() (map[string]models.FileMetadata, error) {
+	stateMap, err := p.List()
+	if err != nil {
+		return nil, err
+	}
+	for relPath, meta := range stateMap {
+		if meta.IsDir() {
+			continue
+		}
+		hash, err := p.Hash(relPath)
+		if err != nil {
+			return nil, err
+		}
+		meta.Hash = hash
+		stateMap[relPath] = meta
+	}
+	return stateMap, nil
+}
+
+// Returns plaintext metadata for the specified file.
+func (p *EncryptedProvider) GetMetadata(relativePath string) (models.FileMetadata, error) {
+	meta, err := p.Stat(relativePath)
+	if err != nil || meta.IsDir() {
+		return meta, err
+	}
+	meta.Hash, err = p.Hash(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	return meta, nil
+}
+
+// Returns a reader that transparently decrypts the specified file.
+func (p *EncryptedProvider) GetReader(relativePath string) (io.ReadCloser, error) {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := p.inner.GetReader(storedPath)
+	if err != nil {
+		return nil, err
+	}
+	header, err := p.parseHeader(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s: %w", relativePath, err)
+	}
+	return &decryptingReader{source: reader, header: header}, nil
+}
+
+// Returns a writer that encrypts into the specified file on Close.
+// Plaintext is staged in a temporary file because the header needs the
+// content hash before the first chunk can be written.
+func (p *EncryptedProvider) GetWriter(relativePath string, modTime time.Time) (io.WriteCloser, error) {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	staging, err := os.CreateTemp("", "file-sync-encrypt-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file for %s: %w", relativePath, err)
+	}
+	return &encryptingWriter{
+		provider:   p,
+		storedPath: storedPath,
+		modTime:    modTime,
+		staging:    staging,
+		hasher:     sha256.New(),
+	}, nil
+}
+
+// Deletes the specified file from the inner provider.
+func (p *EncryptedProvider) DeleteFile(relativePath string) error {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return err
+	}
+	return p.inner.DeleteFile(storedPath)
+}
+
+// Ensures that the specified directory exists in the inner provider.
+func (p *EncryptedProvider) EnsureDir(relativePath string) error {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return err
+	}
+	return p.inner.EnsureDir(storedPath)
+}
+
+// Returns the root path of the inner provider.
+func (p *EncryptedProvider) GetPath() string {
+	return p.inner.GetPath()
+}
+
+// Maps a stored path from the inner provider back to its plaintext path.
+func (p *EncryptedProvider) LogicalPath(storedPath string) (string, error) {
+	if storedPath == encryptionSaltFile {
+		return "", fmt.Errorf("%s is reserved for encryption metadata", storedPath)
+	}
+	if inner, ok := p.inner.(PathMapper); ok {
+		var err error
+		if storedPath, err = inner.LogicalPath(storedPath); err != nil {
+			return "", err
+		}
+	}
+	if !p.encryptNames {
+		return storedPath, nil
+	}
+	parts := strings.Split(storedPath, "/")
+	for i, part := range parts {
+		name, err := p.decryptName(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = name
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Converts inner metadata into plaintext metadata, skipping entries that do
+// not belong to this provider.
+func (p *EncryptedProvider) logicalMetadata(storedPath string, meta models.FileMetadata) (models.FileMetadata, bool) {
+	relPath, err := p.LogicalPath(storedPath)
+	if err != nil {
+		return models.FileMetadata{}, false
+	}
+	if meta.IsDir() {
+		return models.FileMetadata{RelativePath: relPath, Kind: meta.Kind, ModTime: meta.ModTime}, true
+	}
+	size, ok := plaintextSize(meta.Size)
+	if !ok {
+		return models.FileMetadata{}, false
+	}
+	return models.FileMetadata{RelativePath: relPath, ModTime: meta.ModTime, Size: size}, true
+}
+
+// Returns the path under which a plaintext path is stored. Names whose
+// encrypted form would be too long for the inner filesystem are refused.
+func (p *EncryptedProvider) storedPath(relativePath string) (string, error) {
+	if !p.encryptNames {
+		return relativePath, nil
+	}
+	parts := strings.Split(relativePath, "/")
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		parts[i] = p.encryptName(part)
+		if len(parts[i]) > maxNameLength {
+			return "", fmt.Errorf("%w: %s is longer than %d bytes once encrypted", ErrIncompatibleName, relativePath, maxNameLength)
+		}
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Encrypts a single path component deterministically so lookups stay stable.
+// The nonce is an HMAC of the name, which makes it a synthetic IV.
+func (p *EncryptedProvider) encryptName(name string) string {
+	mac := hmac.New(sha256.New, p.nameIVKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:p.nameAEAD.NonceSize()]
+	sealed := p.nameAEAD.Seal(nonce, nonce, []byte(name), nil)
+	return nameEncoding.EncodeToString(sealed)
+}
+
+// Decrypts a single path component produced by encryptName.
+func (p *EncryptedProvider) decryptName(encoded string) (string, error) {
+	sealed, err := nameEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < p.nameAEAD.NonceSize() {
+		return "", fmt.Errorf("%w: invalid encrypted name %q", ErrDecryption, encoded)
+	}
+	nonce := sealed[:p.nameAEAD.NonceSize()]
+	name, err := p.nameAEAD.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: name %q", ErrDecryption, encoded)
+	}
+	return string(name), nil
+}
+
+// Reads and authenticates the header of the specified file.
+func (p *EncryptedProvider) readHeader(relativePath string) (fileHeader, error) {
+	storedPath, err := p.storedPath(relativePath)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	reader, err := p.inner.GetReader(storedPath)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	defer reader.Close()
+	header, err := p.parseHeader(reader)
+	if err != nil {
+		return fileHeader{}, fmt.Errorf("%s: %w", relativePath, err)
+	}
+	return header, nil
+}
+
+// Describes the authenticated header of an encrypted file.
+type fileHeader struct {
+	aead cipher.AEAD
+	size int64
+	hash string
+}
+
+// Derives the content and metadata ciphers for a per-file salt.
+func (p *EncryptedProvider) fileCiphers(salt []byte) (cipher.AEAD, cipher.AEAD, error) {
+	contentKey, err := hkdf.Key(sha256.New, p.masterKey, salt, "file-sync content", encryptionKeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive content key: %w", err)
+	}
+	metaKey, err := hkdf.Key(sha256.New, p.masterKey, salt, "file-sync metadata", encryptionKeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive metadata key: %w", err)
+	}
+	content, err := newGCM(contentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := newGCM(metaKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, meta, nil
+}
+
+// Reads the header from the start of an encrypted stream.
+func (p *EncryptedProvider) parseHeader(reader io.Reader) (fileHeader, error) {
+	buf := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return fileHeader{}, fmt.Errorf("%w: short header", ErrDecryption)
+	}
+	if string(buf[:len(encryptionMagic)]) != encryptionMagic {
+		return fileHeader{}, fmt.Errorf("%w: not an encrypted file", ErrDecryption)
+	}
+	salt := buf[len(encryptionMagic) : len(encryptionMagic)+encryptionSaltSize]
+	content, meta, err := p.fileCiphers(salt)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	nonce := make([]byte, meta.NonceSize())
+	plain, err := meta.Open(nil, nonce, buf[len(encryptionMagic)+encryptionSaltSize:], buf[:len(encryptionMagic)+encryptionSaltSize])
+	if err != nil {
+		return fileHeader{}, fmt.Errorf("%w: header authentication failed", ErrDecryption)
+	}
+	return fileHeader{
+		aead: content,
+		size: int64(binary.BigEndian.Uint64(plain[:8])),
+		hash: hex.EncodeToString(plain[8:]),
+	}, nil
+}
+
+// Builds a header for a file with the given plaintext size and hash.
+func (p *EncryptedProvider) newHeader(size int64, sum []byte) ([]byte, cipher.AEAD, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate file salt: %w", err)
+	}
+	content, meta, err := p.fileCiphers(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := append([]byte(encryptionMagic), salt...)
+	plain := binary.BigEndian.AppendUint64(nil, uint64(size))
+	plain = append(plain, sum...)
+	nonce := make([]byte, meta.NonceSize())
+	return meta.Seal(prefix, nonce, plain, prefix), content, nil
+}
+
+// Returns the number of chunks used to store a plaintext of the given size.
+func chunkCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encryptionChunkSize - 1) / encryptionChunkSize
+}
+
+// Derives the plaintext size from the stored size of an encrypted file.
+func plaintextSize(storedSize int64) (int64, bool) {
+	body := storedSize - int64(encryptionHeaderSize)
+	if body < encryptionTagSize {
+		return 0, false
+	}
+	chunks := (body + encryptionChunkSize + encryptionTagSize - 1) / (encryptionChunkSize + encryptionTagSize)
+	return body - chunks*encryptionTagSize, true
+}
+
+// Returns the nonce for a chunk; the final chunk is flagged so truncation is detected.
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if last {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// Creates an AES-256-GCM cipher for the given key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// Decrypts chunks from an encrypted stream as they are read.
+type decryptingReader struct {
+	source io.ReadCloser
+	header fileHeader
+	index  int64
+	buf    []byte
+	done   bool
+}
+
+// Reads decrypted data, authenticating one chunk at a time.
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Reads and opens the next chunk.
+func (r *decryptingReader) nextChunk() error {
+	total := chunkCount(r.header.size)
+	last := r.index == total-1
+	length := int64(encryptionChunkSize)
+	if last {
+		length = r.header.size - r.index*encryptionChunkSize
+	}
+	sealed := make([]byte, length+encryptionTagSize)
+	if _, err := io.ReadFull(r.source, sealed); err != nil {
+		return fmt.Errorf("%w: truncated chunk %d", ErrDecryption, r.index)
+	}
+	plain, err := r.header.aead.Open(sealed[:0], chunkNonce(r.index, last), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed authentication", ErrDecryption, r.index)
+	}
+	if last {
+		if n, _ := r.source.Read(make([]byte, 1)); n != 0 {
+			return fmt.Errorf("%w: trailing data after final chunk", ErrDecryption)
+		}
+		r.done = true
+	}
+	r.index++
+	r.buf = plain
+	return nil
+}
+
+// Closes the underlying stream.
+func (r *decryptingReader) Close() error {
+	return r.source.Close()
+}
+
+// Stages plaintext locally and encrypts it into the inner provider on Close.
+type encryptingWriter struct {
+	provider   *EncryptedProvider
+	storedPath string
+	modTime    time.Time
+	staging    *os.File
+	hasher     interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+	size int64
+}
+
+// Writes plaintext to the staging file while hashing it.
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	n, err := w.staging.Write(p)
+	w.hasher.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// Discards the staged plaintext without writing to the inner provider.
+func (w *encryptingWriter) Abort() error {
+	w.staging.Close()
+	return os.Remove(w.staging.Name())
+}
+
+// Encrypts the staged plaintext into the inner provider.
+func (w *encryptingWriter) Close() error {
+	defer os.Remove(w.staging.Name())
+	defer w.staging.Close()
+
+	if _, err := w.staging.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind staging file: %w", err)
+	}
+	header, aead, err := w.provider.newHeader(w.size, w.hasher.Sum(nil))
+	if err != nil {
+		return err
+	}
+	dst, err := w.provider.inner.GetWriter(w.storedPath, w.modTime)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		AbortWriter(dst)
+		return fmt.Errorf("failed to write header for %s: %w", w.storedPath, err)
+	}
+
+	total := chunkCount(w.size)
+	chunk := make([]byte, encryptionChunkSize, encryptionChunkSize+encryptionTagSize)
+	for index := int64(0); index < total; index++ {
+		n, err := io.ReadFull(w.staging, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			AbortWriter(dst)
+			return fmt.Errorf("failed to read staging file: %w", err)
+		}
+		sealed := aead.Seal(chunk[:0], chunkNonce(index, index == total-1), chunk[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			AbortWriter(dst)
+			return fmt.Errorf("failed to write chunk for %s: %w", w.storedPath, err)
+		}
+		chunk = chunk[:encryptionChunkSize]
+	}
+	return dst.Close()
+}
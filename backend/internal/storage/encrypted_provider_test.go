@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates an EncryptedProvider over a fresh directory, returning both.
+func newTestEncryptedProvider(t *testing.T, cfg EncryptionConfig) (*EncryptedProvider, *FileSystemProvider) {
+	t.Helper()
+	inner, err := NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewEncryptedProvider(inner, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, inner
+}
+
+// Writes data to a provider through GetWriter.
+func writeTestFile(t *testing.T, provider StorageProvider, relPath string, data []byte) {
+	t.Helper()
+	writer, err := provider.GetWriter(relPath, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reads a whole file from a provider through GetReader.
+func readTestFile(provider StorageProvider, relPath string) ([]byte, error) {
+	reader, err := provider.GetReader(relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Returns the hex SHA256 of data, as providers report it.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Writes a raw key file and returns its path.
+func testKeyFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{7}, encryptionKeySize), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	provider, _ := newTestEncryptedProvider(t, EncryptionConfig{KeyFile: testKeyFile(t)})
+	sizes := []int{
+		0,
+		1,
+		encryptionChunkSize - 1,
+		encryptionChunkSize,
+		encryptionChunkSize + 1,
+		3*encryptionChunkSize + 17,
+	}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rand.Read(data)
+		writeTestFile(t, provider, "file.bin", data)
+
+		got, err := readTestFile(provider, "file.bin")
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: round trip returned %d different bytes", size, len(got))
+		}
+		meta, err := provider.GetMetadata("file.bin")
+		if err != nil {
+			t.Fatalf("size %d: metadata: %v", size, err)
+		}
+		if meta.Size != int64(size) {
+			t.Errorf("size %d: Stat reported %d", size, meta.Size)
+		}
+		if want := hashBytes(data); meta.Hash != want {
+			t.Errorf("size %d: hash %s, want %s", size, meta.Hash, want)
+		}
+	}
+}
+
+func TestEncryptedRejectsTampering(t *testing.T) {
+	provider, inner := newTestEncryptedProvider(t, EncryptionConfig{KeyFile: testKeyFile(t)})
+	data := make([]byte, 2*encryptionChunkSize+100)
+	rand.Read(data)
+	writeTestFile(t, provider, "file.bin", data)
+	storedPath := filepath.Join(inner.GetPath(), "file.bin")
+	stored, err := os.ReadFile(storedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondChunk := encryptionHeaderSize + encryptionChunkSize + encryptionTagSize
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"flipped byte in first chunk", func(b []byte) []byte { b[encryptionHeaderSize+10] ^= 1; return b }},
+		{"flipped byte in last chunk", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"flipped byte in header", func(b []byte) []byte { b[len(encryptionMagic)+3] ^= 1; return b }},
+		{"dropped final chunk", func(b []byte) []byte { return b[:secondChunk+encryptionChunkSize+encryptionTagSize] }},
+		{"truncated final chunk", func(b []byte) []byte { return b[:len(b)-5] }},
+		{"trailing data", func(b []byte) []byte { return append(b, 0) }},
+		{"swapped chunks", func(b []byte) []byte {
+			first := bytes.Clone(b[encryptionHeaderSize:secondChunk])
+			copy(b[encryptionHeaderSize:], b[secondChunk:secondChunk+len(first)])
+			copy(b[secondChunk:], first)
+			return b
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(storedPath, tt.mutate(bytes.Clone(stored)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readTestFile(provider, "file.bin"); !errors.Is(err, ErrDecryption) {
+				t.Fatalf("read returned %v, want ErrDecryption", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedWrongPassphrase(t *testing.T) {
+	provider, inner := newTestEncryptedProvider(t, EncryptionConfig{Passphrase: "correct horse"})
+	writeTestFile(t, provider, "secret.txt", []byte("attack at dawn"))
+
+	wrong, err := NewEncryptedProvider(inner, EncryptionConfig{Passphrase: "battery staple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readTestFile(wrong, "secret.txt"); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("read with wrong passphrase returned %v, want ErrDecryption", err)
+	}
+	if _, err := wrong.Hash("secret.txt"); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("hash with wrong passphrase returned %v, want ErrDecryption", err)
+	}
+
+	right, err := NewEncryptedProvider(inner, EncryptionConfig{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readTestFile(right, "secret.txt")
+	if err != nil || string(got) != "attack at dawn" {
+		t.Fatalf("read with reused salt returned %q, %v", got, err)
+	}
+}
+
+func TestEncryptedNames(t *testing.T) {
+	keyFile := testKeyFile(t)
+	provider, inner := newTestEncryptedProvider(t, EncryptionConfig{KeyFile: keyFile, EncryptNames: true})
+	if err := provider.EnsureDir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, provider, "docs/Report.txt", []byte("hello"))
+
+	first, err := provider.storedPath("docs/Report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewEncryptedProvider(inner, EncryptionConfig{KeyFile: keyFile, EncryptNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := again.storedPath("docs/Report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("stored path changed between providers: %s and %s", first, second)
+	}
+	if strings.Contains(first, "docs") || strings.Contains(first, "Report") {
+		t.Fatalf("stored path %s leaks the plaintext name", first)
+	}
+	if strings.ToLower(first) != first {
+		t.Fatalf("stored path %s is not lowercase", first)
+	}
+
+	listed, err := provider.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := listed["docs/Report.txt"]; !ok || len(listed) != 2 {
+		t.Fatalf("List returned %v", listed)
+	}
+	got, err := readTestFile(again, "docs/Report.txt")
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("read through second provider returned %q, %v", got, err)
+	}
+}
+
+func TestEncryptedNameLength(t *testing.T) {
+	provider, _ := newTestEncryptedProvider(t, EncryptionConfig{KeyFile: testKeyFile(t), EncryptNames: true})
+
+	longest := strings.Repeat("a", 131)
+	storedPath, err := provider.storedPath(longest)
+	if err != nil {
+		t.Fatalf("name of %d bytes refused: %v", len(longest), err)
+	}
+	if len(storedPath) > maxNameLength {
+		t.Fatalf("stored name is %d bytes", len(storedPath))
+	}
+	writeTestFile(t, provider, longest, []byte("fits"))
+
+	tooLong := "dir/" + longest + "a"
+	if _, err := provider.storedPath(tooLong); !errors.Is(err, ErrIncompatibleName) {
+		t.Fatalf("storedPath returned %v, want ErrIncompatibleName", err)
+	}
+	if _, err := provider.GetWriter(tooLong, time.Now()); !errors.Is(err, ErrIncompatibleName) {
+		t.Fatalf("GetWriter returned %v, want ErrIncompatibleName", err)
+	}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Wraps a StorageProvider, storing every path under the escaped form of a
+// NameProfile so names the destination does not allow can still be written.
+// Listing reverses the escaping, so callers only ever see logical paths.
+//
+// Escaping is reversible only for names written through the wrapper: a name
+// already on the destination that happens to contain "%" followed by two hex
+// digits is read back unescaped.
+type EscapedNamesProvider struct {
+	inner   StorageProvider
+	profile NameProfile
+}
+
+// Creates a new EscapedNamesProvider around inner.
+func NewEscapedNamesProvider(inner StorageProvider, profile NameProfile) *EscapedNamesProvider {
+	return &EscapedNamesProvider{inner: inner, profile: profile}
+}
+
+// Lists the inner provider under logical paths.
+func (p *EscapedNamesProvider) List() (map[string]models.FileMetadata, error) {
+	stored, err := p.inner.List()
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored), nil
+}
+
+// Lists the entries below the specified directory under logical paths.
+func (p *EscapedNamesProvider) ListTree(relativePath string) (map[string]models.FileMetadata, error) {
+	stored, err := ListTree(p.inner, p.profile.EscapePath(relativePath))
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored), nil
+}
+
+// Returns the metadata of the specified file under its logical path.
+func (p *EscapedNamesProvider) Stat(relativePath string) (models.FileMetadata, error) {
+	meta, err := p.inner.Stat(p.profile.EscapePath(relativePath))
+	if err != nil {
+		return meta, err
+	}
+	meta.RelativePath = relativePath
+	return meta, nil
+}
+
+// Returns the hash of the specified file.
+func (p *EscapedNamesProvider) Hash(relativePath string) (string, error) {
+	return p.inner.Hash(p.profile.EscapePath(relativePath))
+}
+
+// Builds the state map of the inner provider under logical paths.
+func (p *EscapedNamesProvider) BuildStateMap() (map[string]models.FileMetadata, error) {
+	stored, err := p.inner.BuildStateMap()
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalMap(stored), nil
+}
+
+// Returns the fully hashed metadata of the specified file under its logical path.
+func (p *EscapedNamesProvider) GetMetadata(relativePath string) (models.FileMetadata, error) {
+	meta, err := p.inner.GetMetadata(p.profile.EscapePath(relativePath))
+	if err != nil {
+		return meta, err
+	}
+	meta.RelativePath = relativePath
+	return meta, nil
+}
+
+// Returns a reader for the specified file.
+func (p *EscapedNamesProvider) GetReader(relativePath string) (io.ReadCloser, error) {
+	return p.inner.GetReader(p.profile.EscapePath(relativePath))
+}
+
+// Returns a reader with random access to the specified file.
+func (p *EscapedNamesProvider) GetReaderAt(relativePath string) (ReadAtCloser, error) {
+	randomAccess, ok := p.inner.(RandomAccessProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: random access to %s", errors.ErrUnsupported, relativePath)
+	}
+	return randomAccess.GetReaderAt(p.profile.EscapePath(relativePath))
+}
+
+// Returns a writer for the specified file.
+func (p *EscapedNamesProvider) GetWriter(relativePath string, modTime time.Time) (io.WriteCloser, error) {
+	return p.inner.GetWriter(p.profile.EscapePath(relativePath), modTime)
+}
+
+// Creates a link at the specified path. The target is kept as is.
+func (p *EscapedNamesProvider) CreateLink(relativePath, target string) error {
+	linker, ok := p.inner.(LinkProvider)
+	if !ok {
+		return fmt.Errorf("%w: destination of %s cannot store links", ErrSymlinkSkipped, relativePath)
+	}
+	return linker.CreateLink(p.profile.EscapePath(relativePath), target)
+}
+
+// Applies attributes to the specified file when the inner provider supports them.
+func (p *EscapedNamesProvider) SetAttributes(relativePath string, attrs models.FileAttributes) error {
+	if setter, ok := p.inner.(AttributeSetter); ok {
+		return setter.SetAttributes(p.profile.EscapePath(relativePath), attrs)
+	}
+	return nil
+}
+
+// Forwards the symlink policy to the inner provider.
+func (p *EscapedNamesProvider) SetSymlinkPolicy(policy string) {
+	if setter, ok := p.inner.(SymlinkPolicySetter); ok {
+		setter.SetSymlinkPolicy(policy)
+	}
+}
+
+// Deletes the specified file.
+func (p *EscapedNamesProvider) DeleteFile(relativePath string) error {
+	return p.inner.DeleteFile(p.profile.EscapePath(relativePath))
+}
+
+// Renames a file or directory in place.
+func (p *EscapedNamesProvider) Move(fromPath, toPath string) error {
+	mover, ok := p.inner.(Mover)
+	if !ok {
+		return fmt.Errorf("%w: moving %s", errors.ErrUnsupported, fromPath)
+	}
+	return mover.Move(p.profile.EscapePath(fromPath), p.profile.EscapePath(toPath))
+}
+
+// Ensures that the specified directory exists.
+func (p *EscapedNamesProvider) EnsureDir(relativePath string) error {
+	return p.inner.EnsureDir(p.profile.EscapePath(relativePath))
+}
+
+// Returns the root path of the inner provider.
+func (p *EscapedNamesProvider) GetPath() string {
+	return p.inner.GetPath()
+}
+
+// Maps a stored path, as found on disk, back to its logical path.
+func (p *EscapedNamesProvider) LogicalPath(storedPath string) (string, error) {
+	if inner, ok := p.inner.(PathMapper); ok {
+		var err error
+		if storedPath, err = inner.LogicalPath(storedPath); err != nil {
+			return "", err
+		}
+	}
+	return UnescapePath(storedPath), nil
+}
+
+// Rekeys a map of stored entries by logical path.
+func (p *EscapedNamesProvider) logicalMap(stored map[string]models.FileMetadata) map[string]models.FileMetadata {
+	stateMap := make(map[string]models.FileMetadata, len(stored))
+	for storedPath, meta := range stored {
+		meta.RelativePath = UnescapePath(storedPath)
+		stateMap[meta.RelativePath] = meta
+	}
+	return stateMap
+}
@@ -4,16 +4,26 @@ import (
 	"backend/internal/models"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"time"
+	"unicode/utf8"
 )
 
+// Permission bits carried over between roots.
+const syncedModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Longest file name, in bytes, that common filesystems accept.
+const maxNameLength = 255
+
 // Implements StorageProvider for local filesystem storage.
 type FileSystemProvider struct {
 	rootPath string
+	symlinks string
 }
 
 // Creates a new FileSystemProvider rooted at the given path.
@@ -25,21 +35,39 @@ func NewFileSystemProvider(rootPath string) (*FileSystemProvider, error) {
 	return &FileSystemProvider{rootPath: absPath}, nil
 }
 
-// Builds a map of the current state of the filesystem.
-func (p *FileSystemProvider) BuildStateMap() (map[string]models.FileMetadata, error) {
+// Sets how symbolic links under the root are treated. Links are skipped
+// unless a policy is set.
+func (p *FileSystemProvider) SetSymlinkPolicy(policy string) {
+	p.symlinks = policy
+}
+
+// Lists every file, directory and, under SymlinkCopy, link below the root
+// with size and mod time, without hashing.
+func (p *FileSystemProvider) List() (map[string]models.FileMetadata, error) {
+	return p.listBelow(p.rootPath)
+}
+
+// Lists the entries below the specified directory like List.
+func (p *FileSystemProvider) ListTree(relativePath string) (map[string]models.FileMetadata, error) {
+	fullPath, err := p.locate(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	stateMap, err := p.listBelow(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]models.FileMetadata), nil
+	}
+	return stateMap, err
+}
+
+// Walks the tree below dir, which is the root or a directory inside it.
+func (p *FileSystemProvider) listBelow(dir string) (map[string]models.FileMetadata, error) {
 	stateMap := make(map[string]models.FileMetadata)
-	err := filepath.WalkDir(p.rootPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		base := filepath.Base(path)
-		if len(base) > 0 && base[0] == '.' {
+	err := WalkSubtree(p.rootPath, dir, p.symlinks, func(path string, info os.FileInfo) error {
+		if base := info.Name(); len(base) > 0 && base[0] == '.' {
 			return nil
 		}
-		meta, err := p.metadataForAbsolute(path)
+		meta, err := p.metadataFromInfo(path, info)
 		if err != nil {
 			return fmt.Errorf("error getting metadata for %s: %w", path, err)
 		}
@@ -47,57 +75,192 @@ func (p *FileSystemProvider) BuildStateMap() (map[string]models.FileMetadata, er
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error walking directory %s: %w", p.rootPath, err)
+		return nil, fmt.Errorf("error walking directory %s: %w", dir, err)
+	}
+	return stateMap, nil
+}
+
+// Builds a map of the current state of the filesystem, hashing every file.
+func (p *FileSystemProvider) BuildStateMap() (map[string]models.FileMetadata, error) {
+	stateMap, err := p.List()
+	if err != nil {
+		return nil, err
+	}
+	for relPath, meta := range stateMap {
+		if !meta.IsFile() {
+			continue
+		}
+		hash, err := p.Hash(relPath)
+		if err != nil {
+			return nil, err
+		}
+		meta.Hash = hash
+		stateMap[relPath] = meta
 	}
 	return stateMap, nil
 }
 
 // Returns a reader for the specified file.
 func (p *FileSystemProvider) GetReader(relativePath string) (io.ReadCloser, error) {
-	fullPath := filepath.Join(p.rootPath, relativePath)
-	file, err := os.Open(fullPath)
+	file, err := p.open(relativePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", fullPath, err)
+		return nil, fmt.Errorf("failed to open file %s: %w", relativePath, err)
 	}
 	return file, nil
 }
 
+// Returns size and mod time for the specified file, without hashing.
+func (p *FileSystemProvider) Stat(relativePath string) (models.FileMetadata, error) {
+	fullPath, info, err := p.resolve(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, fmt.Errorf("error stating file %s: %w", fullPath, err)
+	}
+	return p.metadataFromInfo(fullPath, info)
+}
+
+// Computes the content hash of the specified file.
+func (p *FileSystemProvider) Hash(relativePath string) (string, error) {
+	hash, err := p.hashFile(relativePath)
+	if err != nil {
+		return "", fmt.Errorf("error computing hash for file %s: %w", relativePath, err)
+	}
+	return hash, nil
+}
+
 // Returns metadata for the specified file.
 func (p *FileSystemProvider) GetMetadata(relativePath string) (models.FileMetadata, error) {
-	fullPath := filepath.Join(p.rootPath, relativePath)
-	return p.metadataForAbsolute(fullPath)
+	meta, err := p.Stat(relativePath)
+	if err != nil || !meta.IsFile() {
+		return meta, err
+	}
+	meta.Hash, err = p.Hash(relativePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	return meta, nil
 }
 
 // Returns a writer for the specified file.
+// Data is written to a hidden temporary file next to the target and renamed
+// into place on Close, so readers of the previous version are not disturbed.
 func (p *FileSystemProvider) GetWriter(relativePath string, modTime time.Time) (io.WriteCloser, error) {
-	fullPath := filepath.Join(p.rootPath, relativePath)
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+	fullPath, err := p.locate(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.FromSlash(relativePath)
+	if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		root.Close()
 		return nil, fmt.Errorf("failed to ensure directory for %s: %w", fullPath, err)
 	}
-	file, err := os.Create(fullPath)
+	file, tempPath, err := createTempSibling(root, name)
 	if err != nil {
+		root.Close()
 		return nil, fmt.Errorf("failed to create file %s: %w", fullPath, err)
 	}
 	return &writerWithModTime{
-		filePath: fullPath,
+		root:     root,
+		filePath: name,
+		tempPath: tempPath,
 		file:     file,
 		modTime:  modTime,
 	}, nil
 }
 
+// Returns a random-access reader for the specified file.
+func (p *FileSystemProvider) GetReaderAt(relativePath string) (ReadAtCloser, error) {
+	file, err := p.open(relativePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", relativePath, err)
+	}
+	return file, nil
+}
+
+// Replaces the specified entry with a symbolic link to target.
+func (p *FileSystemProvider) CreateLink(relativePath, target string) error {
+	fullPath, err := p.locate(relativePath)
+	if err != nil {
+		return err
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	name := filepath.FromSlash(relativePath)
+	if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to ensure directory for %s: %w", fullPath, err)
+	}
+	dir, base := filepath.Split(name)
+	tempPath := filepath.Join(dir, tempName(base))
+	if err := root.Symlink(target, tempPath); err != nil {
+		return fmt.Errorf("failed to create link %s: %w", fullPath, err)
+	}
+	if err := root.Rename(tempPath, name); err != nil {
+		root.Remove(tempPath)
+		return fmt.Errorf("failed to move link %s into place: %w", fullPath, err)
+	}
+	return nil
+}
+
 // Deletes the specified file.
 func (p *FileSystemProvider) DeleteFile(relativePath string) error {
-	fullPath := filepath.Join(p.rootPath, relativePath)
-	if err := os.RemoveAll(fullPath); err != nil && !os.IsNotExist(err) {
+	fullPath, err := p.locate(relativePath)
+	if err != nil {
+		return err
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := root.RemoveAll(filepath.FromSlash(relativePath)); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete %s: %w", fullPath, err)
 	}
 	return nil
 }
 
+// Renames a file or directory, creating parent directories of the target.
+func (p *FileSystemProvider) Move(fromPath, toPath string) error {
+	fullFrom, err := p.locate(fromPath)
+	if err != nil {
+		return err
+	}
+	fullTo, err := p.locate(toPath)
+	if err != nil {
+		return err
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	to := filepath.FromSlash(toPath)
+	if err := root.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fmt.Errorf("failed to ensure directory for %s: %w", fullTo, err)
+	}
+	if err := root.Rename(filepath.FromSlash(fromPath), to); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", fullFrom, fullTo, err)
+	}
+	return nil
+}
+
 // Ensures that the specified directory exists.
 func (p *FileSystemProvider) EnsureDir(relativePath string) error {
-	fullPath := filepath.Join(p.rootPath, relativePath)
-	if err := os.MkdirAll(fullPath, 0755); err != nil {
+	fullPath, err := p.locate(relativePath)
+	if err != nil {
+		return err
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := root.MkdirAll(filepath.FromSlash(relativePath), 0755); err != nil {
 		return fmt.Errorf("failed to ensure directory %s: %w", fullPath, err)
 	}
 	return nil
@@ -108,49 +271,234 @@ func (p *FileSystemProvider) GetPath() string {
 	return p.rootPath
 }
 
-// Retrieves metadata for a file given its absolute path.
-func (p *FileSystemProvider) metadataForAbsolute(fullPath string) (models.FileMetadata, error) {
-	info, err := os.Stat(fullPath)
-	if err != nil {
-		return models.FileMetadata{}, fmt.Errorf("error stating file %s: %w", fullPath, err)
-	}
+// Builds the metadata of an entry from its file info.
+func (p *FileSystemProvider) metadataFromInfo(fullPath string, info os.FileInfo) (models.FileMetadata, error) {
 	relPath, err := filepath.Rel(p.rootPath, fullPath)
 	if err != nil {
 		return models.FileMetadata{}, fmt.Errorf("error getting relative path for file %s: %w", fullPath, err)
 	}
-	relPath = filepath.ToSlash(relPath)
-	hash, err := hashFile(fullPath)
+	meta := models.FileMetadata{RelativePath: filepath.ToSlash(relPath), ModTime: info.ModTime()}
+	switch {
+	case info.IsDir():
+		meta.Kind = models.KindDirectory
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return models.FileMetadata{}, fmt.Errorf("error reading link %s: %w", fullPath, err)
+		}
+		meta.Kind = models.KindSymlink
+		meta.LinkTarget = target
+	default:
+		meta.Size = info.Size()
+		meta.Attrs = &models.FileAttributes{Mode: info.Mode() & syncedModeBits}
+		if err := readPlatformAttributes(fullPath, info, meta.Attrs); err != nil {
+			return models.FileMetadata{}, fmt.Errorf("error reading attributes of %s: %w", fullPath, err)
+		}
+	}
+	return meta, nil
+}
+
+// Applies permissions, ownership and extended attributes to the specified
+// file. Ownership is only changed when running as root.
+func (p *FileSystemProvider) SetAttributes(relativePath string, attrs models.FileAttributes) error {
+	fullPath, info, err := p.resolve(relativePath)
 	if err != nil {
-		return models.FileMetadata{}, fmt.Errorf("error computing hash for file %s: %w", fullPath, err)
+		return err
 	}
-	return models.FileMetadata{
-		RelativePath: relPath,
-		Hash:         hash,
-		ModTime:      info.ModTime(),
-	}, nil
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot set attributes of %s: not a regular file", fullPath)
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	file, err := root.Open(filepath.FromSlash(relativePath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return applyAttributes(file, attrs)
+}
+
+// Applies permissions, ownership and extended attributes to an open regular
+// file.
+func applyAttributes(file *os.File, attrs models.FileAttributes) error {
+	// Changing the owner clears setuid and setgid, so the mode comes last.
+	if attrs.Owned && os.Geteuid() == 0 {
+		if err := file.Chown(attrs.UID, attrs.GID); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %w", file.Name(), err)
+		}
+	}
+	if err := writePlatformAttributes(file, attrs); err != nil {
+		return fmt.Errorf("failed to set extended attributes of %s: %w", file.Name(), err)
+	}
+	if err := file.Chmod(attrs.Mode); err != nil {
+		return fmt.Errorf("failed to change mode of %s: %w", file.Name(), err)
+	}
+	return nil
 }
 
-// Computes the SHA256 hash of a file at the given path.
-func hashFile(path string) (string, error) {
-	file, err := os.Open(path)
+// Returns the full path and file info of an entry as the symlink policy lets
+// it be seen: as a link under SymlinkCopy and as the link's target under
+// SymlinkFollow. Links under SymlinkSkip, paths behind links and links that
+// lead outside the root are refused with ErrSymlinkSkipped.
+func (p *FileSystemProvider) resolve(relativePath string) (string, os.FileInfo, error) {
+	fullPath, err := p.locate(relativePath)
 	if err != nil {
-		return "", fmt.Errorf("error opening file %s: %w", path, err)
+		return relativePath, nil, err
+	}
+	info, err := os.Lstat(fullPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return fullPath, info, err
+	}
+	switch p.symlinks {
+	case SymlinkCopy:
+		return fullPath, info, nil
+	case SymlinkFollow:
+		realRoot, err := filepath.EvalSymlinks(p.rootPath)
+		if err != nil {
+			return fullPath, nil, err
+		}
+		_, info, err = followLink(fullPath, realRoot)
+		return fullPath, info, err
+	}
+	return fullPath, nil, fmt.Errorf("%w: %s is a link", ErrSymlinkSkipped, fullPath)
+}
+
+// Returns the full path of a relative path that names an entry inside the
+// root and whose parent directories are reached without leaving the root.
+// Every method validates its paths here, so none of them can be pointed
+// outside the root.
+func (p *FileSystemProvider) locate(relativePath string) (string, error) {
+	if err := ValidatePath(relativePath); err != nil {
+		return "", err
+	}
+	fullPath := filepath.Join(p.rootPath, filepath.FromSlash(relativePath))
+	if err := p.checkInside(fullPath); err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
+
+// Checks that the existing parent directories of a path are reached without
+// leaving the root: under SymlinkFollow through links inside the root only,
+// otherwise through no links at all.
+func (p *FileSystemProvider) checkInside(fullPath string) error {
+	realRoot, err := filepath.EvalSymlinks(p.rootPath)
+	if errors.Is(err, os.ErrNotExist) || fullPath == p.rootPath {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(fullPath)
+	realDir, err := filepath.EvalSymlinks(dir)
+	for errors.Is(err, os.ErrNotExist) && dir != p.rootPath {
+		dir = filepath.Dir(dir)
+		realDir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return err
+	}
+	relDir, err := filepath.Rel(p.rootPath, dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case realDir == filepath.Join(realRoot, relDir):
+		return nil
+	case !within(realRoot, realDir):
+		return fmt.Errorf("%w: %s lies behind a link to %s (%w)", ErrSymlinkSkipped, fullPath, realDir, ErrOutsideRoot)
+	case p.symlinks == SymlinkFollow:
+		return nil
+	}
+	return fmt.Errorf("%w: %s lies behind a link", ErrSymlinkSkipped, fullPath)
+}
+
+// Opens a file for reading, refusing links the policy does not follow. The
+// file is opened relative to the root with openat-style resolution, so a link
+// swapped in after the checks cannot lead the open outside the root.
+func (p *FileSystemProvider) open(relativePath string) (*os.File, error) {
+	fullPath, info, err := p.resolve(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, fmt.Errorf("%w: %s is copied as a link", ErrSymlinkSkipped, fullPath)
+	}
+	root, err := p.openRoot()
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.Open(filepath.FromSlash(relativePath))
+}
+
+// Opens the root for operations that must not be led outside it by links
+// swapped in after the checks in locate. Every change to the tree goes
+// through one.
+func (p *FileSystemProvider) openRoot() (*os.Root, error) {
+	root, err := os.OpenRoot(p.rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open root %s: %w", p.rootPath, err)
+	}
+	return root, nil
+}
+
+// Computes the SHA256 hash of the specified file.
+func (p *FileSystemProvider) hashFile(relativePath string) (string, error) {
+	file, err := p.open(relativePath)
+	if err != nil {
+		return "", err
 	}
 	defer file.Close()
 
 	h := sha256.New()
 	if _, err := io.Copy(h, file); err != nil {
-		return "", fmt.Errorf("error reading file %s for hashing: %w", path, err)
+		return "", fmt.Errorf("error reading file %s for hashing: %w", file.Name(), err)
 	}
 
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// Wraps an os.File to set modification time on Close.
+// Creates a hidden temporary file in the same directory as the named file
+// inside root, returning it with its name relative to root. Unlike
+// os.CreateTemp it uses default permissions, as os.Create would.
+func createTempSibling(root *os.Root, name string) (*os.File, string, error) {
+	dir, base := filepath.Split(name)
+	for attempt := 0; ; attempt++ {
+		tempPath := filepath.Join(dir, tempName(base))
+		file, err := root.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if err == nil || !os.IsExist(err) || attempt >= 10 {
+			return file, tempPath, err
+		}
+	}
+}
+
+// Returns a hidden name for a temporary sibling of base, shortening base so
+// the name stays within maxNameLength.
+func tempName(base string) string {
+	suffix := fmt.Sprintf(".sync-tmp-%d", rand.Uint32())
+	if limit := maxNameLength - 1 - len(suffix); len(base) > limit {
+		base = base[:limit]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+	}
+	return "." + base + suffix
+}
+
+// Wraps a temporary os.File and moves it into place with the requested
+// modification time and attributes on Close. Both paths are relative to the
+// root, which the writer holds open until it is closed or aborted.
 type writerWithModTime struct {
+	root     *os.Root
 	filePath string
+	tempPath string
 	file     *os.File
 	modTime  time.Time
+	attrs    *models.FileAttributes
 }
 
 // Writes data to the underlying file.
@@ -158,16 +506,43 @@ func (w *writerWithModTime) Write(p []byte) (int, error) {
 	return w.file.Write(p)
 }
 
-// Closes the underlying file and sets the modification time.
+// Records attributes to apply to the temporary file before it is renamed
+// into place.
+func (w *writerWithModTime) SetAttributes(attrs models.FileAttributes) {
+	w.attrs = &attrs
+}
+
+// Closes and removes the temporary file, leaving the target untouched.
+func (w *writerWithModTime) Abort() error {
+	defer w.root.Close()
+	w.file.Close()
+	return w.root.Remove(w.tempPath)
+}
+
+// Sets the temporary file's attributes, closes it, sets its modification
+// time and renames it into place.
 func (w *writerWithModTime) Close() error {
+	defer w.root.Close()
+	if w.attrs != nil {
+		if err := applyAttributes(w.file, *w.attrs); err != nil {
+			w.file.Close()
+			w.root.Remove(w.tempPath)
+			return fmt.Errorf("failed to apply attributes to %s: %w", w.filePath, err)
+		}
+	}
 	if err := w.file.Close(); err != nil {
+		w.root.Remove(w.tempPath)
 		return err
 	}
-	if w.modTime.IsZero() {
-		return nil
+	if !w.modTime.IsZero() {
+		if err := w.root.Chtimes(w.tempPath, w.modTime, w.modTime); err != nil {
+			w.root.Remove(w.tempPath)
+			return fmt.Errorf("failed to preserve mod time for %s: %w", w.filePath, err)
+		}
 	}
-	if err := os.Chtimes(w.filePath, w.modTime, w.modTime); err != nil {
-		return fmt.Errorf("failed to preserve mod time for %s: %w", w.filePath, err)
+	if err := w.root.Rename(w.tempPath, w.filePath); err != nil {
+		w.root.Remove(w.tempPath)
+		return fmt.Errorf("failed to move %s into place: %w", w.filePath, err)
 	}
 	return nil
 }
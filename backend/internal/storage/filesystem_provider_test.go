@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"backend/internal/models"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterAppliesAttributesBeforeRename(t *testing.T) {
+	fs, err := NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := NewCompressedProvider(fs, CompressionConfig{Level: gzip.DefaultCompression})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, provider := range map[string]StorageProvider{"filesystem": fs, "compressed": compressed} {
+		for _, mode := range []os.FileMode{0o640, 0o400, 0o751} {
+			relPath := filepath.ToSlash(filepath.Join(name, mode.String()))
+			writer, err := provider.GetWriter(relPath, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !SetWriterAttributes(writer, models.FileAttributes{Mode: mode}) {
+				t.Fatalf("%s writer does not take attributes", name)
+			}
+			if _, err := writer.Write([]byte("content")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(filepath.Join(fs.GetPath(), relPath)); !os.IsNotExist(err) {
+				t.Fatalf("%s: file published before Close", relPath)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("%s: %v", relPath, err)
+			}
+			info, err := os.Stat(filepath.Join(fs.GetPath(), relPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode() & syncedModeBits; got != mode {
+				t.Errorf("%s: published with mode %v", relPath, got)
+			}
+		}
+	}
+}
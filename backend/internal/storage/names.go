@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Naming rules of a destination. Names that break them fail to be written,
+// and names that fold together overwrite each other.
+type NameProfile struct {
+	Name string
+	// Names differing only in case refer to the same entry.
+	CaseInsensitive bool
+	// Names differing only in Unicode normalization (NFC or NFD) refer to
+	// the same entry.
+	NormalizationInsensitive bool
+	// Characters that may not appear in a name, besides "/".
+	IllegalChars string
+	// Control characters may not appear in a name.
+	NoControlChars bool
+	// Names may not end in a dot or a space.
+	NoTrailingDotOrSpace bool
+	// Device names such as CON or LPT1 are reserved, with or without an
+	// extension.
+	ReservedDeviceNames bool
+	// Names the destination does not allow are escaped rather than refused.
+	Escape bool
+}
+
+// Naming rules of common destinations.
+var nameProfiles = map[string]NameProfile{
+	"posix": {Name: "posix"},
+	"macos": {
+		Name:                     "macos",
+		CaseInsensitive:          true,
+		NormalizationInsensitive: true,
+		IllegalChars:             ":",
+	},
+	"windows": {
+		Name:                 "windows",
+		CaseInsensitive:      true,
+		IllegalChars:         `<>:"\|?*`,
+		NoControlChars:       true,
+		NoTrailingDotOrSpace: true,
+		ReservedDeviceNames:  true,
+	},
+}
+
+// Device names Windows reserves in every directory.
+var reservedDeviceNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Returned, wrapped in a NameError, for paths a destination cannot store
+// under their own name.
+var ErrIncompatibleName = errors.New("incompatible file name")
+
+// Describes a path that breaks the naming rules of a destination or that
+// would collide there with another path.
+type NameError struct {
+	Path     string
+	Profile  string
+	Reason   string
+	Conflict string
+}
+
+// Describes the problem with the path.
+func (e *NameError) Error() string {
+	if e.Conflict != "" {
+		return fmt.Sprintf("%s: %s collides with %s on %s", ErrIncompatibleName, e.Path, e.Conflict, e.Profile)
+	}
+	return fmt.Sprintf("%s: %s %s, which %s does not allow", ErrIncompatibleName, e.Path, e.Reason, e.Profile)
+}
+
+// Makes errors.Is match ErrIncompatibleName.
+func (e *NameError) Unwrap() error {
+	return ErrIncompatibleName
+}
+
+// Returns the naming rules of a known destination: posix, macos or windows.
+func ParseNameProfile(name string) (NameProfile, error) {
+	profile, ok := nameProfiles[name]
+	if !ok {
+		return NameProfile{}, fmt.Errorf("invalid name profile %q: expected posix, macos or windows", name)
+	}
+	return profile, nil
+}
+
+// Checks every component of a slash-separated path against the profile's
+// naming rules.
+func (p NameProfile) Check(relPath string) error {
+	for _, name := range strings.Split(relPath, "/") {
+		if reason := p.problem(name); reason != "" {
+			return &NameError{Path: relPath, Profile: p.Name, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// Describes why a single name breaks the profile's rules, or returns "".
+func (p NameProfile) problem(name string) string {
+	for _, r := range name {
+		if strings.ContainsRune(p.IllegalChars, r) || p.NoControlChars && r < 0x20 {
+			return fmt.Sprintf("contains %q", r)
+		}
+	}
+	if p.NoTrailingDotOrSpace && (strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ")) && name != "." && name != ".." {
+		return "ends in a dot or space"
+	}
+	if p.ReservedDeviceNames && p.reserved(name) {
+		return "is a reserved device name"
+	}
+	return ""
+}
+
+// Reports whether a name is a reserved device name, ignoring its extension.
+func (p NameProfile) reserved(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	return reservedDeviceNames[strings.ToUpper(strings.TrimRight(stem, " "))]
+}
+
+// Returns the form of a path under which the destination tells paths apart:
+// paths with the same key refer to the same entry there.
+func (p NameProfile) Key(relPath string) string {
+	if p.NormalizationInsensitive {
+		relPath = norm.NFC.String(relPath)
+	}
+	if p.CaseInsensitive {
+		relPath = strings.ToLower(relPath)
+	}
+	return relPath
+}
+
+// Reports whether the destination folds names together, so paths can collide.
+func (p NameProfile) Folds() bool {
+	return p.CaseInsensitive || p.NormalizationInsensitive
+}
+
+// Escapes every name of a path the profile does not allow. Offending
+// characters, and "%" itself, are written as "%" followed by two hex digits,
+// so Unescape restores the original path.
+func (p NameProfile) EscapePath(relPath string) string {
+	names := strings.Split(relPath, "/")
+	for i, name := range names {
+		names[i] = p.escapeName(name)
+	}
+	return strings.Join(names, "/")
+}
+
+// Escapes a single name.
+func (p NameProfile) escapeName(name string) string {
+	if name == "" || name == "." || name == ".." {
+		return name
+	}
+	var escaped strings.Builder
+	for i := 0; i < len(name); {
+		// Invalid UTF-8 is copied byte by byte, unchanged.
+		r, size := utf8.DecodeRuneInString(name[i:])
+		last := i+size == len(name)
+		switch {
+		case r == '%',
+			strings.ContainsRune(p.IllegalChars, r),
+			p.NoControlChars && r < 0x20,
+			p.NoTrailingDotOrSpace && last && (r == '.' || r == ' '),
+			p.ReservedDeviceNames && i == 0 && p.reserved(name):
+			fmt.Fprintf(&escaped, "%%%02X", r)
+		default:
+			escaped.WriteString(name[i : i+size])
+		}
+		i += size
+	}
+	return escaped.String()
+}
+
+// Reverses EscapePath. A "%" not followed by two hex digits is kept as is.
+func UnescapePath(storedPath string) string {
+	if !strings.Contains(storedPath, "%") {
+		return storedPath
+	}
+	var path strings.Builder
+	for i := 0; i < len(storedPath); i++ {
+		if storedPath[i] == '%' && i+2 < len(storedPath) {
+			if b, err := strconv.ParseUint(storedPath[i+1:i+3], 16, 8); err == nil {
+				path.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		path.WriteByte(storedPath[i])
+	}
+	return path.String()
+}
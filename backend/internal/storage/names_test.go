@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Returns a known profile with escaping turned on.
+func escapingProfile(t testing.TB, name string) NameProfile {
+	t.Helper()
+	profile, err := ParseNameProfile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Escape = true
+	return profile
+}
+
+func TestNameProfileCheck(t *testing.T) {
+	tests := []struct {
+		profile string
+		path    string
+		wantErr bool
+	}{
+		{"posix", `a:b/c?.txt `, false},
+		{"posix", "con.txt", false},
+		{"macos", "a:b", true},
+		{"macos", "dir/report?.txt", false},
+		{"windows", "dir/report?.txt", true},
+		{"windows", `a\b`, true},
+		{"windows", "tab\there", true},
+		{"windows", "name.", true},
+		{"windows", "name ", true},
+		{"windows", "dir./file", true},
+		{"windows", "./file", false},
+		{"windows", "../file", false},
+		{"windows", "con", true},
+		{"windows", "CON.txt", true},
+		{"windows", "dir/lpt9.tar.gz", true},
+		{"windows", "nul .txt", true},
+		{"windows", "console.txt", false},
+		{"windows", "com10", false},
+		{"windows", "100%.txt", false},
+	}
+	for _, tt := range tests {
+		err := escapingProfile(t, tt.profile).Check(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Check(%q) = %v", tt.profile, tt.path, err)
+		}
+		var nameErr *NameError
+		if err != nil && (!errors.Is(err, ErrIncompatibleName) || !errors.As(err, &nameErr) || nameErr.Path != tt.path) {
+			t.Errorf("%s: Check(%q) returned %#v", tt.profile, tt.path, err)
+		}
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		profile string
+		path    string
+		want    string
+	}{
+		{"posix", "plain/name.txt", "plain/name.txt"},
+		{"posix", "100%/a%41.txt", "100%25/a%2541.txt"},
+		{"macos", "a:b/c", "a%3Ab/c"},
+		{"windows", "what?.txt", "what%3F.txt"},
+		{"windows", `<>:"\|?*`, "%3C%3E%3A%22%5C%7C%3F%2A"},
+		{"windows", "bell\a", "bell%07"},
+		{"windows", "con", "%63on"},
+		{"windows", "Con.txt/aux", "%43on.txt/%61ux"},
+		{"windows", "nul .txt", "%6Eul .txt"},
+		{"windows", "console.txt", "console.txt"},
+		{"windows", "name.", "name%2E"},
+		{"windows", "name..", "name.%2E"},
+		{"windows", "...", "..%2E"},
+		{"windows", "dir /file ", "dir%20/file%20"},
+		{"windows", " ", "%20"},
+		{"windows", "./../a", "./../a"},
+		{"windows", "100%", "100%25"},
+	}
+	for _, tt := range tests {
+		profile := escapingProfile(t, tt.profile)
+		got := profile.EscapePath(tt.path)
+		if got != tt.want {
+			t.Errorf("%s: EscapePath(%q) = %q, want %q", tt.profile, tt.path, got, tt.want)
+		}
+		if err := profile.Check(got); err != nil {
+			t.Errorf("%s: escaped path fails Check: %v", tt.profile, err)
+		}
+		if back := UnescapePath(got); back != tt.path {
+			t.Errorf("%s: UnescapePath(%q) = %q, want %q", tt.profile, got, back, tt.path)
+		}
+	}
+}
+
+func TestUnescapePathKeepsStrayPercent(t *testing.T) {
+	for stored, want := range map[string]string{
+		"100%":      "100%",
+		"%":         "%",
+		"%4":        "%4",
+		"%zz.txt":   "%zz.txt",
+		"a%2":       "a%2",
+		"%41%42%":   "AB%",
+		"%2541":     "%41",
+		"dir%2Fx":   "dir/x",
+		"no escape": "no escape",
+	} {
+		if got := UnescapePath(stored); got != want {
+			t.Errorf("UnescapePath(%q) = %q, want %q", stored, got, want)
+		}
+	}
+}
+
+func TestNameProfileKey(t *testing.T) {
+	nfc := norm.NFC.String("Café/Résumé.txt")
+	nfd := norm.NFD.String("Café/Résumé.txt")
+	if nfc == nfd {
+		t.Fatal("test names do not differ in normalization")
+	}
+	tests := []struct {
+		profile  string
+		a, b     string
+		collides bool
+	}{
+		{"posix", nfc, nfd, false},
+		{"posix", "README", "readme", false},
+		{"macos", nfc, nfd, true},
+		{"macos", nfc, strings.ToUpper(nfd), true},
+		{"macos", "README", "readme", true},
+		{"macos", "a", "b", false},
+		{"windows", "README", "readme", true},
+		{"windows", nfc, nfd, false},
+	}
+	for _, tt := range tests {
+		profile := escapingProfile(t, tt.profile)
+		if got := profile.Key(tt.a) == profile.Key(tt.b); got != tt.collides {
+			t.Errorf("%s: %q and %q collide = %v, want %v", tt.profile, tt.a, tt.b, got, tt.collides)
+		}
+		if tt.collides && !profile.Folds() {
+			t.Errorf("%s: collides but does not fold", tt.profile)
+		}
+	}
+}
+
+func FuzzEscapePath(f *testing.F) {
+	for _, seed := range []string{"", "a/b", "100%", "%41", "con.txt", "dir./x ", "é/é", "a:b|c", "\x00\x1f", "...", "\xa6", "con\xff."} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, relPath string) {
+		for _, name := range []string{"posix", "macos", "windows"} {
+			profile := escapingProfile(t, name)
+			escaped := profile.EscapePath(relPath)
+			if back := UnescapePath(escaped); back != relPath {
+				t.Fatalf("%s: %q escaped to %q and back to %q", name, relPath, escaped, back)
+			}
+			if err := profile.Check(escaped); err != nil {
+				t.Fatalf("%s: %q escaped to %q: %v", name, relPath, escaped, err)
+			}
+		}
+	})
+}
+
+func TestEscapedNamesProviderRoundTrip(t *testing.T) {
+	inner, err := NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := NewEscapedNamesProvider(inner, escapingProfile(t, "windows"))
+	paths := []string{"what?.txt", "con/aux.md", "trailing./dot.", "100%.txt", "plain.txt"}
+	for _, relPath := range paths {
+		writeTestFile(t, provider, relPath, []byte(relPath))
+	}
+	listed, err := provider.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, relPath := range paths {
+		if _, ok := listed[relPath]; !ok {
+			t.Errorf("List is missing %q: %v", relPath, listed)
+		}
+		got, err := readTestFile(provider, relPath)
+		if err != nil || string(got) != relPath {
+			t.Errorf("read %q back as %q, %v", relPath, got, err)
+		}
+	}
+	if _, err := inner.Stat("what%3F.txt"); err != nil {
+		t.Errorf("escaped name not stored: %v", err)
+	}
+}
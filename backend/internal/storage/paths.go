@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Returned, wrapped in one of the errors below, for relative paths that do
+// not name an entry inside a root.
+var ErrUnsafePath = errors.New("unsafe path")
+
+var (
+	// The path is absolute rather than relative to the root.
+	ErrAbsolutePath = fmt.Errorf("%w: absolute path", ErrUnsafePath)
+	// The path climbs out of the root through "..", or names the root itself.
+	ErrPathTraversal = fmt.Errorf("%w: path leaves the root", ErrUnsafePath)
+	// The path reaches outside the root through a symbolic link.
+	ErrOutsideRoot = fmt.Errorf("%w: path resolves outside the root", ErrUnsafePath)
+)
+
+// Checks that a slash-separated relative path names an entry strictly inside
+// a root: it must not be empty, absolute, contain a NUL byte or climb out
+// with "..". Links along the path are not looked at.
+func ValidatePath(relativePath string) error {
+	switch {
+	case strings.HasPrefix(relativePath, "/") || filepath.IsAbs(relativePath) || filepath.VolumeName(relativePath) != "":
+		return fmt.Errorf("%w: %q", ErrAbsolutePath, relativePath)
+	case strings.ContainsRune(relativePath, 0):
+		return fmt.Errorf("%w: %q contains a NUL byte", ErrUnsafePath, relativePath)
+	case !filepath.IsLocal(filepath.FromSlash(relativePath)) || path.Clean(relativePath) == ".":
+		return fmt.Errorf("%w: %q", ErrPathTraversal, relativePath)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"backend/internal/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"a", nil},
+		{"dir/file.txt", nil},
+		{"dir/../file.txt", nil},
+		{"./file", nil},
+		{"..file", nil},
+		{"/etc/passwd", ErrAbsolutePath},
+		{"/", ErrAbsolutePath},
+		{"", ErrPathTraversal},
+		{".", ErrPathTraversal},
+		{"dir/..", ErrPathTraversal},
+		{"..", ErrPathTraversal},
+		{"../x", ErrPathTraversal},
+		{"a/../..", ErrPathTraversal},
+		{"a/../../x", ErrPathTraversal},
+		{"a\x00b", ErrUnsafePath},
+	}
+	for _, tt := range tests {
+		err := ValidatePath(tt.path)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("ValidatePath(%q) = %v, want %v", tt.path, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("ValidatePath(%q) = %v, not an ErrUnsafePath", tt.path, err)
+		}
+	}
+}
+
+func FuzzValidatePath(f *testing.F) {
+	for _, seed := range []string{"a/b", "..", "a/../..", "/abs", "C:/x", `C:\x`, `\\host\share\x`, "a\x00b", "./a/./b/", "a//b", `..\x`} {
+		f.Add(seed)
+	}
+	root := filepath.Join(string(filepath.Separator), "srv", "root")
+	f.Fuzz(func(t *testing.T, relPath string) {
+		if ValidatePath(relPath) != nil {
+			return
+		}
+		joined := filepath.Join(root, filepath.FromSlash(relPath))
+		if joined == root || !within(root, joined) {
+			t.Fatalf("accepted %q, which joins to %q outside %q", relPath, joined, root)
+		}
+	})
+}
+
+// Creates a provider whose root holds a link named "out" to a directory
+// outside it, returning the provider and that directory.
+func newTestProviderWithEscape(t *testing.T) (*FileSystemProvider, string) {
+	t.Helper()
+	rootPath, outside := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(rootPath, "out")); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewFileSystemProvider(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, outside
+}
+
+func TestFileSystemProviderRefusesUnsafePaths(t *testing.T) {
+	for _, policy := range []string{SymlinkSkip, SymlinkCopy, SymlinkFollow} {
+		provider, outside := newTestProviderWithEscape(t)
+		provider.SetSymlinkPolicy(policy)
+
+		tests := []struct {
+			path string
+			want error
+		}{
+			{"/etc/passwd", ErrAbsolutePath},
+			{"../secret", ErrPathTraversal},
+			{"a/../../secret", ErrPathTraversal},
+			{"out/secret", ErrOutsideRoot},
+			{"out/new/file", ErrOutsideRoot},
+		}
+		for _, tt := range tests {
+			operations := map[string]func() error{
+				"GetReader": func() error { _, err := provider.GetReader(tt.path); return err },
+				"Stat":      func() error { _, err := provider.Stat(tt.path); return err },
+				"GetWriter": func() error {
+					writer, err := provider.GetWriter(tt.path, time.Time{})
+					if err == nil {
+						writer.Close()
+					}
+					return err
+				},
+				"CreateLink":    func() error { return provider.CreateLink(tt.path, "target") },
+				"DeleteFile":    func() error { return provider.DeleteFile(tt.path) },
+				"Move":          func() error { return provider.Move(tt.path, "moved") },
+				"MoveInto":      func() error { return provider.Move("missing", tt.path) },
+				"EnsureDir":     func() error { return provider.EnsureDir(tt.path) },
+				"SetAttributes": func() error { return provider.SetAttributes(tt.path, models.FileAttributes{Mode: 0o777}) },
+			}
+			for name, operation := range operations {
+				if err := operation(); !errors.Is(err, tt.want) {
+					t.Errorf("%s: %s(%q) = %v, want %v", policy, name, tt.path, err, tt.want)
+				}
+			}
+		}
+
+		entries, err := os.ReadDir(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("%s: directory outside the root holds %d entries", policy, len(entries))
+		}
+		info, err := os.Stat(filepath.Join(outside, "secret"))
+		if err != nil {
+			t.Fatalf("%s: file outside the root is gone: %v", policy, err)
+		}
+		if info.Mode().Perm() != 0o644 {
+			t.Errorf("%s: file outside the root changed mode to %v", policy, info.Mode().Perm())
+		}
+	}
+}
+
+func TestFileSystemProviderChangesInsideRoot(t *testing.T) {
+	provider, err := NewFileSystemProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.SetSymlinkPolicy(SymlinkCopy)
+	writeTestFile(t, provider, "dir/file.txt", []byte("content"))
+	if err := provider.SetAttributes("dir/file.txt", models.FileAttributes{Mode: 0o600}); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Move("dir/file.txt", "other/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.CreateLink("links/link", "../other/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.EnsureDir("empty/nested"); err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := provider.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta := listed["other/renamed.txt"]; meta.Attrs == nil || meta.Attrs.Mode != 0o600 {
+		t.Errorf("moved file listed as %+v", meta)
+	}
+	if meta := listed["links/link"]; !meta.IsSymlink() || meta.LinkTarget != "../other/renamed.txt" {
+		t.Errorf("link listed as %+v", meta)
+	}
+	if meta := listed["empty/nested"]; !meta.IsDir() {
+		t.Errorf("directory listed as %+v", meta)
+	}
+	if _, ok := listed["dir/file.txt"]; ok {
+		t.Error("moved file still listed at its old path")
+	}
+
+	if err := provider.DeleteFile("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.DeleteFile("missing"); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+	if _, err := provider.Stat("other/renamed.txt"); err == nil {
+		t.Error("deleted directory still holds its file")
+	}
+	entries, err := os.ReadDir(filepath.Join(provider.GetPath(), "links"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("link directory holds %d entries, want only the link", len(entries))
+	}
+}
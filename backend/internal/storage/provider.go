@@ -3,11 +3,20 @@ package storage
 import (
 	"backend/internal/models"
 	"io"
+	"strings"
 	"time"
 )
 
 // Defines the interface for storage backends.
+//
+// List and Stat are expected to be cheap: they return size, mod time and a
+// provider-native SHA256 checksum when one is available, but never read file
+// contents. Hash reads the content on demand. BuildStateMap and GetMetadata
+// are the fully hashed equivalents.
 type StorageProvider interface {
+	List() (map[string]models.FileMetadata, error)
+	Stat(relativePath string) (models.FileMetadata, error)
+	Hash(relativePath string) (string, error)
 	BuildStateMap() (map[string]models.FileMetadata, error)
 	GetReader(relativePath string) (io.ReadCloser, error)
 	GetWriter(relativePath string, modTime time.Time) (io.WriteCloser, error)
@@ -16,3 +25,102 @@ type StorageProvider interface {
 	EnsureDir(relativePath string) error
 	GetPath() string
 }
+
+// Implemented by wrapping providers whose stored names differ from the
+// logical relative paths, so watcher events on their root can be mapped back.
+type PathMapper interface {
+	LogicalPath(storedPath string) (string, error)
+}
+
+// Combines random-access reads with Close.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Implemented by providers that can read arbitrary ranges of a file, which
+// allows them to act as the basis of a delta transfer.
+type RandomAccessProvider interface {
+	GetReaderAt(relativePath string) (ReadAtCloser, error)
+}
+
+// Implemented by providers that can store symbolic links themselves, which
+// copying links under SymlinkCopy requires of the destination.
+type LinkProvider interface {
+	CreateLink(relativePath, target string) error
+}
+
+// Implemented by providers whose roots may contain symbolic links, so the
+// engine can tell them how to treat links.
+type SymlinkPolicySetter interface {
+	SetSymlinkPolicy(policy string)
+}
+
+// Implemented by providers that can apply the permissions, ownership and
+// extended attributes they report in FileMetadata.Attrs.
+type AttributeSetter interface {
+	SetAttributes(relativePath string, attrs models.FileAttributes) error
+}
+
+// Implemented by writers that can apply permissions, ownership and extended
+// attributes to the file before Close publishes it, so the file never appears
+// with the wrong attributes.
+type AttributeWriter interface {
+	SetAttributes(attrs models.FileAttributes)
+}
+
+// Implemented by providers that can rename files and directories in place.
+type Mover interface {
+	Move(fromPath, toPath string) error
+}
+
+// Implemented by providers that can list the entries below one directory
+// without walking the whole root.
+type TreeLister interface {
+	ListTree(relativePath string) (map[string]models.FileMetadata, error)
+}
+
+// Lists the entries below a directory like List, keyed by their path from
+// the root. A missing directory lists empty. Providers that are not a
+// TreeLister are listed whole and filtered.
+func ListTree(provider StorageProvider, relativePath string) (map[string]models.FileMetadata, error) {
+	if lister, ok := provider.(TreeLister); ok {
+		return lister.ListTree(relativePath)
+	}
+	entries, err := provider.List()
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(relativePath, "/") + "/"
+	for entryPath := range entries {
+		if !strings.HasPrefix(entryPath, prefix) {
+			delete(entries, entryPath)
+		}
+	}
+	return entries, nil
+}
+
+// Implemented by writers that can discard what was written instead of
+// publishing it on Close.
+type Aborter interface {
+	Abort() error
+}
+
+// Discards a writer's content when it supports aborting and closes it
+// otherwise, for use when a copy fails part way through.
+func AbortWriter(writer io.WriteCloser) error {
+	if aborter, ok := writer.(Aborter); ok {
+		return aborter.Abort()
+	}
+	return writer.Close()
+}
+
+// Hands attributes to a writer that applies them before publishing the file.
+// Returns false when the writer cannot take them.
+func SetWriterAttributes(writer io.WriteCloser, attrs models.FileAttributes) bool {
+	if setter, ok := writer.(AttributeWriter); ok {
+		setter.SetAttributes(attrs)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policies for symbolic links found under a root.
+const (
+	// Leaves links and everything behind them out.
+	SymlinkSkip = "skip"
+	// Syncs the link itself, recreating it on the other side.
+	SymlinkCopy = "copy"
+	// Syncs what a link points to as long as it stays inside the root.
+	SymlinkFollow = "follow"
+)
+
+// Returned for paths the symlink policy keeps out of sync: links under
+// SymlinkSkip, paths behind links, and links that lead outside the root or
+// back into themselves.
+var ErrSymlinkSkipped = errors.New("symbolic link skipped by policy")
+
+// Validates a symlink policy name.
+func ParseSymlinkPolicy(policy string) (string, error) {
+	switch policy {
+	case SymlinkSkip, SymlinkCopy, SymlinkFollow:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid symlink policy %q: expected skip, copy or follow", policy)
+}
+
+// Walks the tree below root, calling fn with the path and file info of every
+// entry. Links are left out under SymlinkSkip and reported as links under
+// SymlinkCopy. Under SymlinkFollow they are reported as their targets and
+// followed into directories, except when they lead outside the root or into
+// a directory that is being walked already. Returning filepath.SkipDir from fn
+// for a directory skips its contents.
+func WalkTree(root, policy string, fn func(fullPath string, info os.FileInfo) error) error {
+	return WalkSubtree(root, root, policy, fn)
+}
+
+// Walks the tree below dir, a directory inside root, like WalkTree. Links
+// are still followed anywhere inside root.
+func WalkSubtree(root, dir, policy string, fn func(fullPath string, info os.FileInfo) error) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if !within(realRoot, realDir) {
+		return fmt.Errorf("%w: %s resolves to %s", ErrOutsideRoot, dir, realDir)
+	}
+	return walkDir(dir, realDir, realRoot, policy, map[string]bool{realRoot: true, realDir: true}, fn)
+}
+
+// Walks one directory for WalkTree. realDir is where dir actually lives and
+// ancestors holds the real paths of the directories being walked.
+func walkDir(dir, realDir, realRoot, policy string, ancestors map[string]bool, fn func(string, os.FileInfo) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fullPath := filepath.Join(dir, entry.Name())
+		realPath := filepath.Join(realDir, entry.Name())
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch policy {
+			case SymlinkCopy:
+				if err := fn(fullPath, info); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			case SymlinkFollow:
+				realPath, info, err = followLink(fullPath, realRoot)
+				if err == nil && info.IsDir() && ancestors[realPath] {
+					err = fmt.Errorf("%w: %s loops back to %s", ErrSymlinkSkipped, fullPath, realPath)
+				}
+				if err != nil {
+					log.Printf("Skipping symbolic link: %v\n", err)
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		err = fn(fullPath, info)
+		if err == filepath.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		ancestors[realPath] = true
+		err = walkDir(fullPath, realPath, realRoot, policy, ancestors, fn)
+		delete(ancestors, realPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolves a link to the real path and file info of its target, refusing
+// targets outside the root.
+func followLink(fullPath, realRoot string) (string, os.FileInfo, error) {
+	realPath, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s cannot be resolved: %v", ErrSymlinkSkipped, fullPath, err)
+	}
+	if !within(realRoot, realPath) {
+		return "", nil, fmt.Errorf("%w: %s leads outside the root to %s (%w)", ErrSymlinkSkipped, fullPath, realPath, ErrOutsideRoot)
+	}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return realPath, info, nil
+}
+
+// Reports whether path is root or lies below it.
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
@@ -267,7 +267,7 @@ function App() {
         pushActivity({
           type: 'sync',
           filePath: 'manual',
-          message: 'Manual sync completed',
+          message: 'Manual sync started',
           direction: 'both',
         });
         